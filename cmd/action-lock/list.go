@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+	"github.com/dnd-it/action-lock/internal/outputs"
+)
+
+// lockStatus is the report on a held lock shared by the status and list
+// actions.
+type lockStatus struct {
+	Name       string    `json:"name"`
+	Ref        string    `json:"ref"`
+	Owner      string    `json:"owner,omitempty"`
+	Workflow   string    `json:"workflow,omitempty"`
+	RunURL     string    `json:"run_url,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	AgeSeconds int       `json:"age_seconds"`
+	ExpiresAt  string    `json:"expires_at,omitempty"`
+	Stale      bool      `json:"is_stale"`
+}
+
+func newLockStatus(name string, info *lock.Info, cfg *inputs.Config, now time.Time) lockStatus {
+	display := name
+	if cfg.EncodeLockName {
+		if decoded, ok := inputs.DecodeLockName(name); ok {
+			display = decoded
+		}
+	}
+	s := lockStatus{
+		Name:       display,
+		Ref:        fmt.Sprintf("refs/locks/%s", name),
+		AcquiredAt: info.AcquiredAt().UTC(),
+		AgeSeconds: int(now.Sub(info.AcquiredAt()).Seconds()),
+		Stale:      info.Stale(now, time.Duration(cfg.StaleThreshold)*time.Second),
+	}
+	if h := info.Holder; h != nil {
+		s.Owner = h.Owner
+		s.Workflow = h.Workflow
+		s.RunURL = h.RunURL(cfg.ServerURL)
+		s.Actor = h.Actor
+		s.Reason = h.Reason
+	}
+	if exp := info.ExpiresAt(); !exp.IsZero() {
+		s.ExpiresAt = exp.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// list reports every lock under the lock_name prefix as a JSON output and as
+// a table in the job summary.
+func list(ctx context.Context, client *lock.Client, cfg *inputs.Config) error {
+	entries, err := client.List(ctx, cfg.LockName)
+	if err != nil {
+		return fmt.Errorf("failed to list locks: %w", err)
+	}
+
+	now := time.Now()
+	locks := make([]lockStatus, 0, len(entries))
+	for _, e := range entries {
+		locks = append(locks, newLockStatus(e.Name, e.Info, cfg, now))
+	}
+
+	data, err := json.Marshal(locks)
+	if err != nil {
+		return err
+	}
+	outputs.Set("locks", string(data))
+	outputs.Set("count", strconv.Itoa(len(locks)))
+	outputs.Summary(summaryTable(locks))
+	fmt.Printf("Found %d locks\n", len(locks))
+	return nil
+}
+
+// summaryTable renders locks as a Markdown table for the job summary.
+func summaryTable(locks []lockStatus) string {
+	var b strings.Builder
+	b.WriteString("### Locks\n\n")
+	if len(locks) == 0 {
+		b.WriteString("No locks are held.\n")
+		return b.String()
+	}
+
+	b.WriteString("| Lock | Holder | Actor | Age | Expires | Stale |\n")
+	b.WriteString("|------|--------|-------|-----|---------|-------|\n")
+	for _, l := range locks {
+		holder := "unknown (legacy lock)"
+		if l.RunURL != "" {
+			holder = fmt.Sprintf("[%s](%s)", markdownEscape(l.Workflow), l.RunURL)
+		} else if l.Workflow != "" {
+			holder = markdownEscape(l.Workflow)
+		}
+		if l.Reason != "" {
+			holder += ": " + markdownEscape(l.Reason)
+		}
+		stale := "no"
+		if l.Stale {
+			stale = "yes"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s |\n",
+			l.Name, holder, markdownEscape(l.Actor),
+			time.Duration(l.AgeSeconds)*time.Second, l.ExpiresAt, stale)
+	}
+	return b.String()
+}
+
+// markdownEscape keeps free-form text from breaking out of a table cell.
+func markdownEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ", "[", `\[`, "]", `\]`).Replace(s)
+}
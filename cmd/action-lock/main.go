@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dnd-it/action-lock/internal/inputs"
@@ -11,69 +18,616 @@ import (
 )
 
 func main() {
-	cfg, err := inputs.Parse()
+	// Without arguments the action is configured through the INPUT_*
+	// environment; the command-line form is for use outside of workflows.
+	// The action's post step runs the same entrypoint with the same inputs
+	// and tells itself apart by the state the main step saved.
+	var cfg *inputs.Config
+	var err error
+	post := false
+	if len(os.Args) > 1 {
+		cfg, err = inputs.ParseArgs(os.Args[1:])
+	} else {
+		post = inputs.IsPost()
+		if !post {
+			outputs.SaveState("isPost", "true")
+		}
+		cfg, err = inputs.Parse()
+	}
 	if err != nil {
+		if post {
+			return // already reported by the main step
+		}
 		outputs.Error(err.Error())
 		os.Exit(1)
 	}
 
-	client := lock.New(cfg.Repository, cfg.Token)
-	lockRef := fmt.Sprintf("refs/locks/%s", cfg.LockName)
+	// Cancelling the job sends SIGINT or SIGTERM: stop waiting and clean up
+	// rather than being killed in the middle of an acquisition.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := newClient(cfg)
+	if err != nil {
+		outputs.Error(err.Error())
+		os.Exit(1)
+	}
+	if post {
+		autoRelease(ctx, client, cfg)
+		return
+	}
 
 	switch cfg.Action {
 	case "acquire":
-		acquired := acquire(client, cfg)
-		outputs.Set("acquired", fmt.Sprintf("%t", acquired))
-		outputs.Set("lock_ref", lockRef)
-		if !acquired && cfg.FailOnTimeout {
-			outputs.Error(fmt.Sprintf("Failed to acquire lock %q within %ds", cfg.LockName, cfg.Timeout))
-			os.Exit(1)
+		h := holder(cfg)
+		names, reentered, err := acquireAll(ctx, client, cfg, h)
+		outputs.Set("acquired", fmt.Sprintf("%t", names != nil))
+		outputs.Set("reentered", fmt.Sprintf("%t", reentered))
+		if names == nil {
+			outputs.Set("lock_ref", lockRefs(cfg.LockNames))
+			if err != nil {
+				outputs.Error(fmt.Sprintf("Failed to acquire lock %q: %v", cfg.LockName, err))
+				os.Exit(1)
+			}
+			if ctx.Err() != nil {
+				outputs.Error(fmt.Sprintf("Cancelled while waiting for lock %q", cfg.LockName))
+				os.Exit(1)
+			}
+			if cfg.FailOnTimeout {
+				outputs.Error(fmt.Sprintf("Failed to acquire lock %q within %ds", cfg.LockName, cfg.Timeout))
+				os.Exit(1)
+			}
+			return
+		}
+		outputs.Set("lock_ref", lockRefs(names))
+		if cfg.MaxHolders > 1 && len(names) == 1 {
+			outputs.Set("slot", strconv.Itoa(slices.Index(slots(cfg), names[0])))
 		}
+		outputs.Set("owner_token", h.Token)
+		outputs.SaveState("owner_token", h.Token)
+		// Release and renew steps later in the job find the token here;
+		// other jobs of the run, like matrix legs, don't share it.
+		for _, name := range cfg.LockNames {
+			outputs.ExportVariable(inputs.OwnerTokenVar(name), h.Token)
+		}
+		outputs.SaveState("locks", strings.Join(names, ","))
 	case "release":
-		release(client, cfg)
+		released := true
+		for _, name := range cfg.LockNames {
+			released = release(ctx, client, forLock(cfg, name)) && released
+		}
 		outputs.Set("acquired", "false")
-		outputs.Set("lock_ref", lockRef)
+		outputs.Set("released", fmt.Sprintf("%t", released))
+		outputs.Set("lock_ref", lockRefs(cfg.LockNames))
+	case "renew":
+		renewed := true
+		for _, name := range cfg.LockNames {
+			renewed = renew(ctx, client, forLock(cfg, name)) && renewed
+		}
+		outputs.Set("renewed", fmt.Sprintf("%t", renewed))
+		outputs.Set("lock_ref", lockRefs(cfg.LockNames))
+	case "run":
+		os.Exit(run(ctx, client, cfg))
+	case "status":
+		if err := status(ctx, client, cfg); err != nil {
+			outputs.Error(err.Error())
+			os.Exit(1)
+		}
+		outputs.Set("lock_ref", lockRefs(cfg.LockNames))
+	case "list":
+		if err := list(ctx, client, cfg); err != nil {
+			outputs.Error(err.Error())
+			os.Exit(1)
+		}
+	case "reap":
+		if err := reap(ctx, client, cfg); err != nil {
+			outputs.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
+// newClient returns a client for the lock repository, authenticated as the
+// GitHub App if one is configured and with the token otherwise.
+func newClient(cfg *inputs.Config) (*lock.Client, error) {
+	opts := []lock.Option{lock.WithBaseURL(cfg.APIURL), lock.WithGraphQLURL(cfg.GraphQLURL)}
+	if cfg.AppID != "" {
+		app, err := lock.NewAppTokenSource(cfg.APIURL, cfg.AppID, cfg.AppPrivateKey, cfg.InstallationID, cfg.LockRepository)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, lock.WithTokenSource(app))
+	}
+	return lock.New(cfg.LockRepository, cfg.Token, opts...), nil
+}
+
+// forLock narrows the configuration to a single one of its locks.
+func forLock(cfg *inputs.Config, lockName string) *inputs.Config {
+	c := *cfg
+	c.LockName = lockName
+	c.LockNames = []string{lockName}
+	return &c
+}
+
+// lockRefs formats lock names as a comma-separated list of full refs.
+func lockRefs(names []string) string {
+	refs := make([]string, len(names))
+	for i, name := range names {
+		refs[i] = fmt.Sprintf("refs/locks/%s", name)
+	}
+	return strings.Join(refs, ",")
+}
+
+// slots returns the lock refs an acquirer may take: the lock itself, or each
+// slot of a counting semaphore.
+func slots(cfg *inputs.Config) []string {
+	if cfg.MaxHolders <= 1 {
+		return []string{cfg.LockName}
+	}
+	names := make([]string, cfg.MaxHolders)
+	for i := range names {
+		names[i] = lock.SlotName(cfg.LockName, i)
+	}
+	return names
+}
+
+// candidates returns the lock refs a release or renewal applies to: the slot
+// or reader given as input, or else every ref the lock may be held under.
+func candidates(ctx context.Context, client *lock.Client, cfg *inputs.Config) []string {
+	switch {
+	case cfg.Mode == "exclusive":
+		return []string{lock.WriterName(cfg.LockName)}
+	case cfg.Mode == "shared" && cfg.OwnerToken != "":
+		return []string{lock.ReaderName(cfg.LockName, cfg.OwnerToken)}
+	case cfg.Mode == "shared":
+		names, err := client.Readers(ctx, cfg.LockName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to list readers: %v\n", err)
+		}
+		return names
+	case cfg.MaxHolders > 1 && cfg.Slot >= 0:
+		return []string{lock.SlotName(cfg.LockName, cfg.Slot)}
 	}
+	return slots(cfg)
 }
 
-func acquire(client *lock.Client, cfg *inputs.Config) bool {
+// acquireAll acquires every lock in their sorted order, so workflows that need
+// overlapping sets of locks can't deadlock each other. If one of them can't be
+// acquired within the timeout, ctx is cancelled or an error rules out
+// acquiring it at all, the locks already taken are released again; locks
+// re-entered from an earlier run of the same owner are left to it. Returns the
+// names of the acquired lock refs, or nil if not all were acquired, whether
+// any of them was re-entered, and the error that stopped the acquisition.
+func acquireAll(ctx context.Context, client *lock.Client, cfg *inputs.Config, h *lock.Holder) ([]string, bool, error) {
 	deadline := time.Now().Add(time.Duration(cfg.Timeout) * time.Second)
+	// Rate limits lasting past the deadline aren't sat out in a request, so
+	// the wait for the locks ends on time.
+	wctx := lock.WithWaitDeadline(ctx, deadline)
+	var held, taken []string
+	anyReentered := false
+	for _, lockName := range cfg.LockNames {
+		lockCfg := forLock(cfg, lockName)
+		name, reentered, err := acquireLock(wctx, client, lockCfg, h, deadline)
+		if name == "" {
+			if err != nil && len(taken) > 0 {
+				fmt.Printf("Lock %q can't be acquired, releasing %d locks already taken\n", lockName, len(taken))
+			} else if ctx.Err() != nil {
+				// A request cut short by the cancellation may have taken
+				// the lock without us learning of it.
+				fmt.Printf("Cancelled while waiting for lock %q, rolling back\n", lockName)
+				taken = append(taken, attempted(lockCfg, h)...)
+			} else if len(taken) > 0 {
+				fmt.Printf("Lock %q not acquired in time, releasing %d locks already taken\n", lockName, len(taken))
+			}
+			rollback(ctx, client, taken, h.Token)
+			return nil, false, err
+		}
+		held = append(held, name)
+		if !reentered {
+			taken = append(taken, name)
+		}
+		anyReentered = anyReentered || reentered
+	}
+	return held, anyReentered, nil
+}
+
+// fatal reports whether err rules out acquiring the lock however long we
+// wait: the token lacks permission, the repository can't be found, or GitHub
+// rejects the lock ref or the commit it points at as invalid.
+func fatal(err error) bool {
+	return errors.Is(err, lock.ErrForbidden) || errors.Is(err, lock.ErrNotFound) ||
+		errors.Is(err, lock.ErrInvalidRef) || errors.Is(err, lock.ErrInvalidSHA)
+}
+
+// attempted returns the lock refs an acquisition of the lock may take.
+func attempted(cfg *inputs.Config, h *lock.Holder) []string {
+	switch cfg.Mode {
+	case "shared":
+		return []string{lock.ReaderName(cfg.LockName, h.Token)}
+	case "exclusive":
+		return []string{lock.WriterName(cfg.LockName)}
+	}
+	return slots(cfg)
+}
+
+// rollback releases those of names that are held under token, giving up the
+// locks of an acquisition that timed out or was cancelled.
+func rollback(ctx context.Context, client *lock.Client, names []string, token string) {
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	for _, name := range names {
+		released, err := client.Release(ctx, name, token)
+		switch {
+		case errors.Is(err, lock.ErrNotOwner):
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: failed to release lock %q: %v\n", name, err)
+		case released:
+			fmt.Printf("Lock %q released\n", name)
+		}
+	}
+}
+
+// cleanupContext returns a context for releasing locks that still works once
+// ctx has been cancelled or its wait deadline has passed, bounded so cleanup
+// can't hang the job.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(lock.WithWaitDeadline(context.WithoutCancel(ctx), time.Time{}), 30*time.Second)
+}
+
+// sleep waits for d and reports false if ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pollDelay returns the wait before the next acquisition attempt: the poll
+// interval, stretched while the API rate limit budget runs low or after a
+// request was rejected by a rate limit, with jitter so that jobs waiting on
+// the same lock spread out rather than polling in lockstep, but no later than
+// the deadline. The jitter never cuts a rate limit wait short.
+func pollDelay(client *lock.Client, cfg *inputs.Config, deadline time.Time) time.Duration {
 	interval := time.Duration(cfg.PollInterval) * time.Second
+	wait := lock.Jitter(interval)
+	if d := client.Throttle(interval); d > interval {
+		fmt.Printf("Sparing the API rate limit, next attempt in %s\n", d.Round(time.Second))
+		wait = max(d, lock.Jitter(d))
+	}
+	return max(0, min(wait, time.Until(deadline)))
+}
 
-	for {
-		acquired, err := client.Acquire(cfg.LockName, cfg.SHA)
+// releaseAll releases lock refs acquired under token, logging the outcome for
+// each.
+func releaseAll(ctx context.Context, client *lock.Client, names []string, token string) {
+	for _, name := range names {
+		released, err := client.Release(ctx, name, token)
+		switch {
+		case errors.Is(err, lock.ErrNotOwner):
+			outputs.Warning(fmt.Sprintf("Lock %q is no longer owned by this run; not releasing", name))
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: failed to release lock %q: %v\n", name, err)
+		case released:
+			fmt.Printf("Lock %q released\n", name)
+		}
+	}
+}
+
+// acquireLock waits for a single configured lock until the deadline and
+// returns the name of the ref acquired, or "" on timeout, whether it was
+// re-entered, and a fatal error that ended the wait early.
+func acquireLock(ctx context.Context, client *lock.Client, cfg *inputs.Config, h *lock.Holder, deadline time.Time) (string, bool, error) {
+	switch cfg.Mode {
+	case "shared":
+		name, err := acquireShared(ctx, client, cfg, h, deadline)
+		return name, false, err
+	case "exclusive":
+		return acquireExclusive(ctx, client, cfg, h, deadline)
+	}
+	return acquire(ctx, client, cfg, slots(cfg), h, deadline)
+}
+
+// acquire polls until one of names is acquired or the deadline passes.
+// Returns the acquired name, or "" on timeout, whether the lock was
+// re-entered, and a fatal error that ended the wait early. A lock already held
+// by the same owner is re-entered right away; otherwise, in fair mode, only
+// waiters at the front of the queue attempt to acquire.
+func acquire(ctx context.Context, client *lock.Client, cfg *inputs.Config, names []string, h *lock.Holder, deadline time.Time) (string, bool, error) {
+	for _, name := range names {
+		info, err := client.Inspect(ctx, name)
+		if fatal(err) {
+			return "", false, err
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: lock attempt failed: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Warning: failed to inspect lock: %v\n", err)
+			continue
 		}
-		if acquired {
-			fmt.Printf("Lock %q acquired\n", cfg.LockName)
-			return true
+		if info != nil && info.OwnedBy(cfg.Owner) && tryReenter(ctx, client, name, info, h) {
+			return name, true, nil
 		}
+	}
 
-		// Check for stale lock (disabled when stale_threshold is 0)
-		age, err := client.LockAge(cfg.LockName)
-		if cfg.StaleThreshold > 0 && err == nil && age > cfg.StaleThreshold {
-			fmt.Printf("Stale lock detected (%ds old, threshold %ds), removing...\n", age, cfg.StaleThreshold)
-			if err := client.Release(cfg.LockName); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: failed to remove stale lock: %v\n", err)
+	var q *queue
+	if cfg.Fair {
+		q = joinQueue(ctx, client, cfg, h)
+		defer func() {
+			ctx, cancel := cleanupContext(ctx)
+			defer cancel()
+			q.leave(ctx)
+		}()
+		defer func() { outputs.Set("queue_position", strconv.Itoa(q.position)) }()
+	}
+
+	for {
+		var info *lock.Info
+		if q == nil || q.turn(ctx) {
+			for _, name := range names {
+				var acquired bool
+				var err error
+				acquired, info, err = tryAcquire(ctx, client, cfg, name, h)
+				if err != nil {
+					return "", false, err
+				}
+				if acquired && info != nil {
+					return name, true, nil
+				}
+				if acquired {
+					fmt.Printf("Lock %q acquired\n", name)
+					return name, false, nil
+				}
 			}
-			continue
 		}
 
-		if time.Now().After(deadline) {
-			return false
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return "", false, nil
 		}
 
+		wait := pollDelay(client, cfg, deadline)
 		remaining := time.Until(deadline).Seconds()
-		fmt.Printf("Lock %q held by another process, retrying in %ds... (%.0fs remaining)\n", cfg.LockName, cfg.PollInterval, remaining)
-		time.Sleep(interval)
+		switch {
+		case q != nil && q.position > cfg.MaxHolders:
+			fmt.Printf("Waiting for lock %q at queue position %d, retrying in %.0fs... (%.0fs remaining)\n", cfg.LockName, q.position, wait.Seconds(), remaining)
+		case len(names) == 1:
+			fmt.Printf("Lock %q held by %s, retrying in %.0fs... (%.0fs remaining)\n", cfg.LockName, describe(info), wait.Seconds(), remaining)
+		default:
+			fmt.Printf("All %d slots of lock %q held, retrying in %.0fs... (%.0fs remaining)\n", len(names), cfg.LockName, wait.Seconds(), remaining)
+		}
+		if q != nil {
+			q.hold(ctx, wait)
+		}
+		if !sleep(ctx, wait) {
+			return "", false, nil
+		}
 	}
 }
 
-func release(client *lock.Client, cfg *inputs.Config) {
-	if err := client.Release(cfg.LockName); err != nil {
+// tryAcquire makes a single attempt at a lock ref, re-entering it if it is
+// held by the same owner and taking it over if it is stale. Returns the
+// current holder if the lock is held by someone else, or the previous holder
+// if it was re-entered. Errors are logged, and only returned if they are
+// fatal.
+func tryAcquire(ctx context.Context, client *lock.Client, cfg *inputs.Config, name string, h *lock.Holder) (bool, *lock.Info, error) {
+	acquired, err := client.Acquire(ctx, name, h)
+	if fatal(err) {
+		return false, nil, err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: lock attempt failed: %v\n", err)
+	}
+	if acquired {
+		return true, nil, nil
+	}
+
+	info, err := client.Inspect(ctx, name)
+	if fatal(err) {
+		return false, nil, err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to inspect lock: %v\n", err)
+	}
+	if info == nil {
+		return false, nil, nil
+	}
+	if info.OwnedBy(cfg.Owner) && tryReenter(ctx, client, name, info, h) {
+		return true, info, nil
+	}
+	if stale, why := isStale(ctx, client, cfg, info); stale {
+		fmt.Printf("Stale lock %q detected (%s, held by %s), taking over...\n", name, why, describe(info))
+		stolen, err := steal(ctx, client, name, info, h)
+		if fatal(err) {
+			return false, nil, err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to take over stale lock: %v\n", err)
+		}
+		if stolen {
+			return true, nil, nil
+		}
+	}
+	return false, info, nil
+}
+
+// steal takes over a stale lock with a commit on top of the stale one, so the
+// ref only moves if no other waiter has taken it over first.
+func steal(ctx context.Context, client *lock.Client, lockName string, info *lock.Info, h *lock.Holder) (bool, error) {
+	meta := *h
+	meta.AcquiredAt = time.Now().UTC()
+	meta.RenewedAt = meta.AcquiredAt
+	sha, err := client.CreateCommit(ctx, lockName, &meta, info.SHA)
+	if err != nil {
+		return false, err
+	}
+	return client.Steal(ctx, lockName, info.SHA, sha)
+}
+
+// tryReenter re-enters a lock held by the same owner, logging the outcome.
+func tryReenter(ctx context.Context, client *lock.Client, name string, info *lock.Info, h *lock.Holder) bool {
+	reentered, err := reenter(ctx, client, name, info, h)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to re-enter lock: %v\n", err)
+	}
+	if reentered {
+		fmt.Printf("Lock %q re-entered (owner %s, previously held by %s)\n", name, h.Owner, describe(info))
+	}
+	return reentered
+}
+
+// reenter records this run as the holder of a lock its owner already holds,
+// with a commit on top of the current one. The lock keeps its acquisition
+// time and passes to this run's owner token, so only the latest run of the
+// owner can release it.
+func reenter(ctx context.Context, client *lock.Client, lockName string, info *lock.Info, h *lock.Holder) (bool, error) {
+	meta := *h
+	meta.AcquiredAt = info.AcquiredAt()
+	meta.RenewedAt = time.Now().UTC()
+	meta.Reentries = info.Holder.Reentries + 1
+	sha, err := client.CreateCommit(ctx, lockName, &meta, info.SHA)
+	if err != nil {
+		return false, err
+	}
+	return client.Steal(ctx, lockName, info.SHA, sha)
+}
+
+// release frees the lock if this job owns it. With force the lock is deleted
+// unconditionally.
+func release(ctx context.Context, client *lock.Client, cfg *inputs.Config) bool {
+	if cfg.Force {
+		released := false
+		for _, name := range candidates(ctx, client, cfg) {
+			if err := client.ForceRelease(ctx, name); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to release lock %q: %v\n", name, err)
+				continue
+			}
+			fmt.Printf("Lock %q force-released\n", name)
+			released = true
+		}
+		return released
+	}
+
+	name, token, ok := owned(ctx, client, cfg)
+	if !ok {
+		return false
+	}
+
+	released, err := client.Release(ctx, name, token)
+	if errors.Is(err, lock.ErrNotOwner) {
+		outputs.Warning(fmt.Sprintf("Lock %q is no longer owned by this run; not releasing (set force: true to override)", name))
+		return false
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: failed to release lock: %v\n", err)
-		return
+		return false
+	}
+	if !released {
+		fmt.Printf("Lock %q is not held\n", name)
+		return false
+	}
+	fmt.Printf("Lock %q released\n", name)
+	return true
+}
+
+// renew extends the lease of a lock this job owns.
+func renew(ctx context.Context, client *lock.Client, cfg *inputs.Config) bool {
+	name, token, ok := owned(ctx, client, cfg)
+	if !ok {
+		return false
+	}
+
+	err := client.Renew(ctx, name, token)
+	if errors.Is(err, lock.ErrNotOwner) {
+		outputs.Warning(fmt.Sprintf("Lock %q is no longer owned by this run; not renewing", name))
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to renew lock: %v\n", err)
+		return false
+	}
+	fmt.Printf("Lock %q renewed\n", name)
+	return true
+}
+
+// owned finds the lock ref this job holds and the token it holds it under:
+// the one matching the owner token given, saved by the acquire step or
+// exported by it to the job. Jobs of a run, like matrix legs, share the run,
+// so without a token there is no telling whether this job took the lock.
+// Reports false, after logging why, if this job holds none of the candidate
+// refs.
+func owned(ctx context.Context, client *lock.Client, cfg *inputs.Config) (string, string, bool) {
+	if cfg.OwnerToken == "" {
+		fmt.Printf("Lock %q was not acquired by this job; not touching it (pass owner_token for a lock acquired elsewhere, or set force: true)\n", cfg.LockName)
+		return "", "", false
+	}
+	names := candidates(ctx, client, cfg)
+	if len(names) == 1 {
+		return names[0], cfg.OwnerToken, true
+	}
+
+	var held *lock.Info
+	for _, name := range names {
+		info, err := client.Inspect(ctx, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to inspect lock %q: %v\n", name, err)
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		if info.Holder != nil && info.Holder.Token == cfg.OwnerToken {
+			return name, cfg.OwnerToken, true
+		}
+		held = info
+	}
+
+	if held == nil {
+		fmt.Printf("Lock %q is not held\n", cfg.LockName)
+		return "", "", false
+	}
+	outputs.Warning(fmt.Sprintf("Lock %q is held by %s, not by this job (set force: true to override)", cfg.LockName, describe(held)))
+	return "", "", false
+}
+
+// holder builds the metadata recorded in the lock commit for this run.
+func holder(cfg *inputs.Config) *lock.Holder {
+	return &lock.Holder{
+		Token:      lock.NewToken(),
+		Owner:      cfg.Owner,
+		Repository: cfg.Repository,
+		RunID:      cfg.RunID,
+		RunAttempt: cfg.RunAttempt,
+		Job:        cfg.Job,
+		Workflow:   cfg.Workflow,
+		Actor:      cfg.Actor,
+		PR:         cfg.PRNumber,
+		SHA:        cfg.SHA,
+		TTL:        cfg.TTL,
+		Reason:     cfg.Reason,
+		// The run action releases when its command exits, and the post
+		// step of acquire at the end of the job unless told not to.
+		JobScoped: cfg.Action == "run" || cfg.AutoRelease,
+	}
+}
+
+// describe summarizes who holds a lock for log messages.
+func describe(info *lock.Info) string {
+	if info == nil {
+		return "another process"
+	}
+	h := info.Holder
+	if h == nil {
+		return fmt.Sprintf("commit %s", info.SHA)
+	}
+	s := fmt.Sprintf("%s (run %d, job %s, actor %s)", h.Workflow, h.RunID, h.Job, h.Actor)
+	if h.PR > 0 {
+		s += fmt.Sprintf(" for PR #%d", h.PR)
+	}
+	if h.Reason != "" {
+		s += fmt.Sprintf(": %s", h.Reason)
 	}
-	fmt.Printf("Lock %q released\n", cfg.LockName)
+	return s
 }
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+)
+
+// fakeGitHub is an in-memory stand-in for the git database API of owner/repo.
+type fakeGitHub struct {
+	t       *testing.T
+	mu      sync.Mutex
+	refs    map[string]string // ref without "refs/" prefix -> commit SHA
+	commits map[string]string // commit SHA -> message
+	created []string          // refs in the order they were created
+	runs    map[int64]string  // workflow run ID -> status
+
+	// forbidden lists refs every request for is rejected with 403.
+	forbidden map[string]bool
+	// cancelOn names a ref whose creation cancels cancel before the client
+	// learns of it, as a cancelled job would.
+	cancelOn string
+	cancel   context.CancelFunc
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *lock.Client) {
+	t.Helper()
+	gh := &fakeGitHub{
+		t:         t,
+		refs:      map[string]string{},
+		commits:   map[string]string{},
+		runs:      map[int64]string{},
+		forbidden: map[string]bool{},
+	}
+	srv := httptest.NewServer(gh)
+	t.Cleanup(srv.Close)
+	return gh, lock.New("owner/repo", "t", lock.WithBaseURL(srv.URL))
+}
+
+func (gh *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
+	if r.URL.Path == "/repos/owner/repo" {
+		_, _ = w.Write([]byte(`{"full_name":"owner/repo"}`))
+		return
+	}
+	if id, ok := strings.CutPrefix(r.URL.Path, "/repos/owner/repo/actions/runs/"); ok {
+		runID, _ := strconv.ParseInt(id, 10, 64)
+		status, ok := gh.runs[runID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": status})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/git/")
+	var payload struct {
+		Ref     string `json:"ref"`
+		SHA     string `json:"sha"`
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+
+	ref := strings.TrimPrefix(payload.Ref, "refs/")
+	for _, p := range []string{"ref/", "refs/"} {
+		if name, ok := strings.CutPrefix(path, p); ok {
+			ref = name
+		}
+	}
+	if gh.forbidden[ref] {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && path == "trees":
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"sha":"tree"}`))
+
+	case r.Method == "POST" && path == "commits":
+		sha := fmt.Sprintf("commit%d", len(gh.commits)+1)
+		gh.commits[sha] = payload.Message
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+
+	case r.Method == "GET" && strings.HasPrefix(path, "commits/"):
+		sha := strings.TrimPrefix(path, "commits/")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"sha":       sha,
+			"message":   gh.commits[sha],
+			"committer": map[string]string{"date": time.Now().Format(time.RFC3339)},
+		})
+
+	case r.Method == "POST" && path == "refs":
+		if _, ok := gh.refs[ref]; ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Reference already exists"}`))
+			return
+		}
+		gh.refs[ref] = payload.SHA
+		gh.created = append(gh.created, ref)
+		if ref == gh.cancelOn {
+			gh.cancel()
+			// Hold the response back until the client has given up on it.
+			gh.mu.Unlock()
+			<-r.Context().Done()
+			gh.mu.Lock()
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == "GET" && strings.HasPrefix(path, "ref/"):
+		sha, ok := gh.refs[ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ref":    "refs/" + ref,
+			"object": map[string]string{"sha": sha},
+		})
+
+	case r.Method == "GET" && strings.HasPrefix(path, "matching-refs/"):
+		prefix := strings.TrimPrefix(path, "matching-refs/")
+		entries := []map[string]any{}
+		for name, sha := range gh.refs {
+			if strings.HasPrefix(name, prefix) {
+				entries = append(entries, map[string]any{
+					"ref":    "refs/" + name,
+					"object": map[string]string{"sha": sha},
+				})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(entries)
+
+	case r.Method == "PATCH" && strings.HasPrefix(path, "refs/"):
+		gh.refs[ref] = payload.SHA
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ref":    "refs/" + ref,
+			"object": map[string]string{"sha": payload.SHA},
+		})
+
+	case r.Method == "DELETE" && strings.HasPrefix(path, "refs/"):
+		if _, ok := gh.refs[ref]; !ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Reference does not exist"}`))
+			return
+		}
+		delete(gh.refs, ref)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		gh.t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// held reports whether the lock exists and, if token isn't empty, is held
+// under token.
+func held(t *testing.T, client *lock.Client, name, token string) bool {
+	t.Helper()
+	info, err := client.Inspect(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return info != nil && (token == "" || info.Holder != nil && info.Holder.Token == token)
+}
+
+func acquireConfig(names ...string) *inputs.Config {
+	return &inputs.Config{
+		Action:         "acquire",
+		LockNames:      names,
+		LockName:       strings.Join(names, ","),
+		PollInterval:   1,
+		StaleThreshold: 600,
+		StalePolicy:    "time",
+		MaxHolders:     1,
+		Slot:           -1,
+		Repository:     "owner/repo",
+		RunID:          1,
+	}
+}
+
+func TestAcquireAll(t *testing.T) {
+	gh, client := newFakeGitHub(t)
+	cfg := acquireConfig("a", "b")
+	h := holder(cfg)
+
+	names, reentered, err := acquireAll(context.Background(), client, cfg, h)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(names, []string{"a", "b"}) || reentered {
+		t.Errorf("unexpected result: %v, %t", names, reentered)
+	}
+	if !held(t, client, "a", h.Token) || !held(t, client, "b", h.Token) {
+		t.Error("expected both locks to be held")
+	}
+	if !slices.Equal(gh.created, []string{"locks/a", "locks/b"}) {
+		t.Errorf("expected the locks to be taken in order, got %v", gh.created)
+	}
+}
+
+func TestAcquireAll_SortedOrder(t *testing.T) {
+	t.Setenv("INPUT_ACTION", "acquire")
+	t.Setenv("INPUT_LOCK_NAME", "b,a")
+	t.Setenv("INPUT_TOKEN", "t")
+	t.Setenv("GITHUB_REPOSITORY", "owner/repo")
+	t.Setenv("GITHUB_SHA", "abc123")
+	cfg, err := inputs.Parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gh, client := newFakeGitHub(t)
+
+	if _, _, err := acquireAll(context.Background(), client, cfg, holder(cfg)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(gh.created, []string{"locks/a", "locks/b"}) {
+		t.Errorf("expected the locks to be taken in sorted order, got %v", gh.created)
+	}
+}
+
+func TestAcquireAll_TimeoutReleasesTaken(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	if _, err := client.Acquire(context.Background(), "b", &lock.Holder{Token: "other"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := acquireConfig("a", "b")
+
+	names, _, err := acquireAll(context.Background(), client, cfg, holder(cfg))
+	if err != nil || names != nil {
+		t.Fatalf("expected a timeout, got %v, %v", names, err)
+	}
+	if held(t, client, "a", "") {
+		t.Error("expected lock a to be released again")
+	}
+	if !held(t, client, "b", "other") {
+		t.Error("expected lock b to stay with its holder")
+	}
+}
+
+func TestAcquireAll_FatalErrorReleasesTaken(t *testing.T) {
+	gh, client := newFakeGitHub(t)
+	gh.forbidden["locks/b"] = true
+	cfg := acquireConfig("a", "b")
+	cfg.Timeout = 60
+
+	names, _, err := acquireAll(context.Background(), client, cfg, holder(cfg))
+	if !errors.Is(err, lock.ErrForbidden) || names != nil {
+		t.Fatalf("expected ErrForbidden, got %v, %v", names, err)
+	}
+	if held(t, client, "a", "") {
+		t.Error("expected lock a to be released again")
+	}
+}
+
+func TestAcquireAll_CancelledReleasesAttempted(t *testing.T) {
+	gh, client := newFakeGitHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gh.cancelOn, gh.cancel = "locks/b", cancel
+	cfg := acquireConfig("a", "b")
+	cfg.Timeout = 60
+
+	names, _, err := acquireAll(ctx, client, cfg, holder(cfg))
+	if err != nil || names != nil {
+		t.Fatalf("expected the acquisition to be cancelled, got %v, %v", names, err)
+	}
+	// Lock b was created although the response never arrived.
+	if held(t, client, "a", "") || held(t, client, "b", "") {
+		t.Errorf("expected both locks to be released, got %v", gh.refs)
+	}
+}
+
+func TestAcquireAll_RollbackKeepsReentered(t *testing.T) {
+	_, client := newFakeGitHub(t)
+	bg := context.Background()
+	if _, err := client.Acquire(bg, "a", &lock.Holder{Token: "earlier", Owner: "pr-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Acquire(bg, "b", &lock.Holder{Token: "other", Owner: "pr-2"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := acquireConfig("a", "b")
+	cfg.Owner = "pr-1"
+	h := holder(cfg)
+
+	names, _, err := acquireAll(bg, client, cfg, h)
+	if err != nil || names != nil {
+		t.Fatalf("expected a timeout, got %v, %v", names, err)
+	}
+	// The owner held lock a before and still needs it.
+	if !held(t, client, "a", h.Token) {
+		t.Error("expected the re-entered lock a to be left in place")
+	}
+	if !held(t, client, "b", "other") {
+		t.Error("expected lock b to stay with its holder")
+	}
+}
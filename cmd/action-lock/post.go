@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+	"github.com/dnd-it/action-lock/internal/outputs"
+)
+
+// autoRelease runs as the post step of the action at the end of the job. It
+// releases the locks the acquire step recorded in the action state, but only
+// those still held under the owner token of that step: a lock that was
+// released explicitly, or has since been taken over, is left alone.
+func autoRelease(ctx context.Context, client *lock.Client, cfg *inputs.Config) {
+	if len(cfg.Held) == 0 {
+		return
+	}
+	if !cfg.AutoRelease {
+		fmt.Printf("Not releasing %d locks: auto_release is off\n", len(cfg.Held))
+		return
+	}
+	if cfg.OwnerToken == "" {
+		outputs.Warning("No owner token recorded by the acquire step; not releasing any locks")
+		return
+	}
+
+	for _, name := range cfg.Held {
+		released, err := client.Release(ctx, name, cfg.OwnerToken)
+		switch {
+		case errors.Is(err, lock.ErrNotOwner):
+			fmt.Printf("Lock %q is held by another owner now, leaving it\n", name)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: failed to release lock %q: %v\n", name, err)
+		case released:
+			fmt.Printf("Lock %q released at the end of the job\n", name)
+		default:
+			fmt.Printf("Lock %q was already released\n", name)
+		}
+	}
+}
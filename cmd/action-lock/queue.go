@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+)
+
+// queue is a waiter's place in the FIFO queue of a lock in fair mode.
+type queue struct {
+	client    *lock.Client
+	cfg       *inputs.Config
+	h         *lock.Holder
+	ttl       int
+	ticket    string
+	lease     int // seconds the ticket was last renewed for
+	renewedAt time.Time
+	position  int
+	// seen caches the tickets ahead by commit SHA across polls.
+	seen map[string]*lock.Info
+}
+
+// joinQueue enqueues a ticket for this waiter. Tickets are renewed while
+// waiting and expire after a few missed polls, so the queue recovers quickly
+// from waiters that died.
+func joinQueue(ctx context.Context, client *lock.Client, cfg *inputs.Config, h *lock.Holder) *queue {
+	q := &queue{
+		client: client,
+		cfg:    cfg,
+		h:      h,
+		ttl:    max(60, 3*cfg.PollInterval),
+		seen:   map[string]*lock.Info{},
+	}
+	q.enqueue(ctx)
+	return q
+}
+
+func (q *queue) enqueue(ctx context.Context) {
+	ticket, err := q.client.Enqueue(ctx, q.cfg.LockName, q.h, q.ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to join queue: %v\n", err)
+		return
+	}
+	q.ticket = ticket
+	q.lease = q.ttl
+	q.renewedAt = time.Now()
+	fmt.Printf("Joined queue for lock %q with ticket %s\n", q.cfg.LockName, ticket)
+}
+
+// turn reports whether this waiter is at the front of the queue, i.e. within
+// the first max_holders live tickets, and may try to acquire the lock.
+func (q *queue) turn(ctx context.Context) bool {
+	if q.ticket == "" {
+		q.enqueue(ctx)
+		if q.ticket == "" {
+			return false
+		}
+	}
+
+	pos, err := q.client.QueuePosition(ctx, q.cfg.LockName, q.ticket, q.seen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to read queue: %v\n", err)
+		return false
+	}
+	if pos == 0 {
+		fmt.Printf("Ticket %s expired, rejoining queue\n", q.ticket)
+		q.ticket = ""
+		return false
+	}
+	q.position = pos
+
+	return pos <= q.cfg.MaxHolders
+}
+
+// hold renews the ticket before the waiter sleeps for wait if it would
+// otherwise come close to expiring before the next poll. The lease covers
+// the wait, which throttling may stretch far beyond the poll interval, so a
+// waiter sparing the rate limit keeps its place in the queue; the renewal
+// after it returns to the usual lease.
+func (q *queue) hold(ctx context.Context, wait time.Duration) {
+	if q.ticket == "" {
+		return
+	}
+	margin := time.Duration(q.ttl) * time.Second / 2
+	if time.Until(q.renewedAt.Add(time.Duration(q.lease)*time.Second)) >= wait+margin {
+		return
+	}
+
+	ttl := max(q.ttl, int((wait+margin)/time.Second)+1)
+	err := q.client.RenewTicket(ctx, q.cfg.LockName, q.ticket, q.h.Token, ttl)
+	switch {
+	case errors.Is(err, lock.ErrNotOwner):
+		q.ticket = ""
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: failed to renew ticket: %v\n", err)
+	default:
+		q.lease = ttl
+		q.renewedAt = time.Now()
+	}
+}
+
+// leave removes this waiter's ticket from the queue.
+func (q *queue) leave(ctx context.Context) {
+	if q.ticket == "" {
+		return
+	}
+	if err := q.client.Dequeue(ctx, q.cfg.LockName, q.ticket); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to leave queue: %v\n", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue_HoldThroughLongWait(t *testing.T) {
+	gh, client := newFakeGitHub(t)
+	ctx := context.Background()
+	cfg := acquireConfig("deploy")
+	cfg.PollInterval = 10
+	cfg.Fair = true
+	q := joinQueue(ctx, client, cfg, holder(cfg))
+	if q.ticket == "" {
+		t.Fatal("expected to join the queue")
+	}
+	ref := "lock-queue/deploy/" + q.ticket
+	sha := gh.refs[ref]
+
+	// A poll interval is well within the lease.
+	q.hold(ctx, 10*time.Second)
+	if gh.refs[ref] != sha {
+		t.Error("expected no renewal before a short wait")
+	}
+
+	// Waiting out a rate limit would outlast it.
+	q.hold(ctx, 5*time.Minute)
+	if gh.refs[ref] == sha {
+		t.Fatal("expected the ticket to be renewed before a long wait")
+	}
+	if q.lease < 5*60+30 {
+		t.Errorf("expected the lease to cover the wait, got %ds", q.lease)
+	}
+	if !q.turn(ctx) || q.position != 1 {
+		t.Errorf("expected to stay at the front of the queue, got position %d", q.position)
+	}
+
+	// Once the long wait is over, the usual lease applies again.
+	q.renewedAt = time.Now().Add(-5 * time.Minute)
+	q.hold(ctx, 10*time.Second)
+	if q.lease != q.ttl {
+		t.Errorf("expected the lease to return to %ds, got %ds", q.ttl, q.lease)
+	}
+}
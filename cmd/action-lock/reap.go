@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+	"github.com/dnd-it/action-lock/internal/outputs"
+)
+
+// reap releases every lock under the lock_name prefix whose workflow run has
+// completed, was cancelled or no longer exists, or whose job ended without
+// releasing it. Only locks released with their job are reaped, unless force
+// is set: others, like those acquired with auto_release off, are meant to
+// outlive their run. Locks without a recorded run, such as those created by
+// older versions of the action, are left alone.
+func reap(ctx context.Context, client *lock.Client, cfg *inputs.Config) error {
+	entries, err := client.List(ctx, cfg.LockName)
+	if err != nil {
+		return fmt.Errorf("failed to list locks: %w", err)
+	}
+
+	reaped := []string{}
+	for _, e := range entries {
+		h := e.Info.Holder
+		if h == nil || h.Token == "" || !h.JobScoped && !cfg.Force {
+			continue
+		}
+		why, known := runEnded(ctx, client, cfg, e.Info)
+		if !known || why == "" {
+			continue
+		}
+
+		// Release with the holder's token so a lock that changed hands since
+		// it was listed is left alone.
+		released, err := client.Release(ctx, e.Name, h.Token)
+		switch {
+		case errors.Is(err, lock.ErrNotOwner):
+			fmt.Printf("Lock %q changed hands, not reaping\n", e.Name)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: failed to release lock %q: %v\n", e.Name, err)
+		case released:
+			fmt.Printf("Reaped lock %q held by %s: %s\n", e.Name, describe(e.Info), why)
+			reaped = append(reaped, e.Name)
+		}
+	}
+
+	data, err := json.Marshal(reaped)
+	if err != nil {
+		return err
+	}
+	outputs.Set("reaped", string(data))
+	outputs.Set("count", strconv.Itoa(len(reaped)))
+	fmt.Printf("Reaped %d of %d locks\n", len(reaped), len(entries))
+	return nil
+}
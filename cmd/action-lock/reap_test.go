@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+)
+
+func TestReap(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		holder lock.Holder
+		force  bool
+		reaped bool
+	}{
+		{"released with its job", lock.Holder{RunID: 1, JobScoped: true}, false, true},
+		{"run in progress", lock.Holder{RunID: 2, JobScoped: true}, false, false},
+		{"meant to outlive its run", lock.Holder{RunID: 1, Owner: "pr-1"}, false, false},
+		{"outliving its run, forced", lock.Holder{RunID: 1, Owner: "pr-1"}, true, true},
+		{"no run", lock.Holder{JobScoped: true}, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gh, client := newFakeGitHub(t)
+			gh.runs[1] = "completed"
+			gh.runs[2] = "in_progress"
+			ctx := context.Background()
+			h := tt.holder
+			h.Token = "holder"
+			if _, err := client.Acquire(ctx, "env/dev", &h); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cfg := &inputs.Config{Action: "reap", LockName: "env/", Repository: "owner/repo", Force: tt.force}
+			if err := reap(ctx, client, cfg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := !held(t, client, "env/dev", ""); got != tt.reaped {
+				t.Errorf("expected reaped %t, got %t", tt.reaped, got)
+			}
+		})
+	}
+}
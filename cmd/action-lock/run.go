@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+	"github.com/dnd-it/action-lock/internal/outputs"
+)
+
+// run acquires the locks, executes the command while renewing their leases in
+// the background, and releases the locks afterwards. Returns the exit code to
+// exit with.
+func run(ctx context.Context, client *lock.Client, cfg *inputs.Config) int {
+	h := holder(cfg)
+	names, _, err := acquireAll(ctx, client, cfg, h)
+	if err != nil {
+		outputs.Error(fmt.Sprintf("Failed to acquire lock %q: %v", cfg.LockName, err))
+		return 1
+	}
+	if names == nil && ctx.Err() != nil {
+		outputs.Error(fmt.Sprintf("Cancelled while waiting for lock %q", cfg.LockName))
+		return 1
+	}
+	if names == nil {
+		outputs.Error(fmt.Sprintf("Failed to acquire lock %q within %ds", cfg.LockName, cfg.Timeout))
+		return 1
+	}
+
+	// Once the command runs, cancellation is forwarded to it, and the leases
+	// are kept alive until it has exited.
+	hbCtx := context.WithoutCancel(ctx)
+	interval := time.Duration(cfg.HeartbeatInterval) * time.Second
+	heartbeats := make([]*lock.Heartbeat, len(names))
+	for i, name := range names {
+		heartbeats[i] = client.StartHeartbeat(hbCtx, name, h.Token, interval, func(err error) {
+			if errors.Is(err, lock.ErrNotOwner) {
+				outputs.Error(fmt.Sprintf("Lock %q was taken over while the command was running", name))
+				return
+			}
+			fmt.Fprintf(os.Stderr, "Warning: failed to renew lock %q: %v\n", name, err)
+		})
+	}
+
+	code := execute(cfg)
+	for _, hb := range heartbeats {
+		hb.Stop()
+	}
+
+	releaseCtx, cancel := cleanupContext(ctx)
+	defer cancel()
+	releaseAll(releaseCtx, client, names, h.Token)
+	return code
+}
+
+// execute runs the command with the action's stdio and returns its exit code.
+// The command given on the command line is executed directly, the command
+// input through the shell. SIGINT and SIGTERM, as sent when the job is
+// cancelled, are forwarded to the command, so that it can shut down before the
+// lock is released.
+func execute(cfg *inputs.Config) int {
+	cmd := exec.Command("sh", "-c", cfg.Command)
+	if len(cfg.Args) > 0 {
+		cmd = exec.Command(cfg.Args[0], cfg.Args[1:]...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		outputs.Error(fmt.Sprintf("Failed to run command: %v", err))
+		return 1
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				fmt.Printf("Received %s, forwarding to command\n", sig)
+				_ = cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Like a shell, report death by signal N as exit code 128+N.
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		outputs.Error(fmt.Sprintf("Failed to run command: %v", err))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+)
+
+// acquireExclusive takes the writer ref of a read-write lock, which stops new
+// readers from entering, and then waits for the current readers to finish.
+// Returns the writer lock name, or "" on timeout or cancellation, whether the
+// writer lock was re-entered, and a fatal error that ended the wait early.
+func acquireExclusive(ctx context.Context, client *lock.Client, cfg *inputs.Config, h *lock.Holder, deadline time.Time) (string, bool, error) {
+	writer, reentered, err := acquire(ctx, client, cfg, []string{lock.WriterName(cfg.LockName)}, h, deadline)
+	if writer == "" {
+		return "", false, err
+	}
+
+	renewEvery := time.Duration(cfg.HeartbeatInterval) * time.Second
+	renewedAt := time.Now()
+
+	for {
+		n, err := client.ActiveReaders(ctx, cfg.LockName, func(info *lock.Info) bool {
+			stale, _ := isStale(ctx, client, cfg, info)
+			return stale
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to check readers: %v\n", err)
+		} else if n == 0 {
+			return writer, reentered, nil
+		}
+
+		// On cancellation the caller rolls back the writer lock.
+		if ctx.Err() != nil {
+			return "", false, nil
+		}
+		if time.Now().After(deadline) {
+			rctx, cancel := cleanupContext(ctx)
+			_, err := client.Release(rctx, writer, h.Token)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to release writer lock: %v\n", err)
+			}
+			return "", false, nil
+		}
+
+		// Keep the writer lease alive while draining readers.
+		if time.Since(renewedAt) > renewEvery {
+			err := client.Renew(ctx, writer, h.Token)
+			if errors.Is(err, lock.ErrNotOwner) {
+				fmt.Fprintf(os.Stderr, "Warning: writer lock %q was taken over while waiting for readers\n", writer)
+				return "", false, nil
+			}
+			if err == nil {
+				renewedAt = time.Now()
+			}
+		}
+
+		wait := pollDelay(client, cfg, deadline)
+		remaining := time.Until(deadline).Seconds()
+		fmt.Printf("Waiting for %d readers of lock %q to finish, retrying in %.0fs... (%.0fs remaining)\n", n, cfg.LockName, wait.Seconds(), remaining)
+		if !sleep(ctx, wait) {
+			return "", false, nil
+		}
+	}
+}
+
+// acquireShared adds a reader ref to a read-write lock once no writer holds
+// or waits for it. Returns the reader lock name, or "" on timeout or
+// cancellation, and a fatal error that ended the wait early.
+func acquireShared(ctx context.Context, client *lock.Client, cfg *inputs.Config, h *lock.Holder, deadline time.Time) (string, error) {
+	reader := lock.ReaderName(cfg.LockName, h.Token)
+
+	for {
+		writer, free := writerAbsent(ctx, client, cfg)
+		if free {
+			acquired, err := client.Acquire(ctx, reader, h)
+			if fatal(err) {
+				return "", err
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: lock attempt failed: %v\n", err)
+			}
+			if acquired {
+				// A writer that arrived between the check and our reader ref
+				// may not have seen us. Writers take precedence, so back off.
+				if writer, free = writerAbsent(ctx, client, cfg); free {
+					fmt.Printf("Lock %q acquired (shared)\n", cfg.LockName)
+					return reader, nil
+				}
+				if _, err := client.Release(ctx, reader, h.Token); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to withdraw reader lock: %v\n", err)
+				}
+			}
+		}
+
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return "", nil
+		}
+
+		wait := pollDelay(client, cfg, deadline)
+		remaining := time.Until(deadline).Seconds()
+		fmt.Printf("Lock %q held or awaited by writer %s, retrying in %.0fs... (%.0fs remaining)\n", cfg.LockName, describe(writer), wait.Seconds(), remaining)
+		if !sleep(ctx, wait) {
+			return "", nil
+		}
+	}
+}
+
+// writerAbsent reports whether no live writer holds or waits for the lock,
+// returning the writer otherwise. Stale writers count as absent.
+func writerAbsent(ctx context.Context, client *lock.Client, cfg *inputs.Config) (*lock.Info, bool) {
+	info, err := client.Inspect(ctx, lock.WriterName(cfg.LockName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to inspect writer lock: %v\n", err)
+		return nil, false
+	}
+	if info == nil {
+		return nil, true
+	}
+	if stale, _ := isStale(ctx, client, cfg, info); stale {
+		return nil, true
+	}
+	return info, false
+}
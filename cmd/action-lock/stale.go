@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+)
+
+// isStale decides under the stale_policy whether a lock may be taken over,
+// and explains why for log messages.
+//
+// The time policy goes by the holder's declared TTL, capped by
+// stale_threshold (0 disables the cap). The run-status policy considers a lock
+// stale as soon as the holder's workflow run or job is no longer in progress,
+// and falls back to time for locks whose run is unknown. Both takes over a
+// lock that is stale by either measure.
+func isStale(ctx context.Context, client *lock.Client, cfg *inputs.Config, info *lock.Info) (bool, string) {
+	byTime := info.Stale(time.Now(), time.Duration(cfg.StaleThreshold)*time.Second)
+	if cfg.StalePolicy == "time" || cfg.StalePolicy == "both" && byTime {
+		return byTime, staleness(info, cfg)
+	}
+
+	why, known := runEnded(ctx, client, cfg, info)
+	if !known {
+		return byTime, staleness(info, cfg)
+	}
+	return why != "", why
+}
+
+// runEnded looks up the holder's workflow run attempt and explains why it no
+// longer holds the lock, or returns "" if it is still in progress. Reports
+// false if the run is unknown: the lock records no run, or the lookup failed.
+func runEnded(ctx context.Context, client *lock.Client, cfg *inputs.Config, info *lock.Info) (string, bool) {
+	h := info.Holder
+	if h == nil || h.RunID == 0 {
+		return "", false
+	}
+	repo := h.Repository
+	if repo == "" {
+		repo = cfg.Repository
+	}
+
+	run, err := client.GetRun(ctx, repo, h.RunID, h.RunAttempt)
+	switch {
+	case errors.Is(err, lock.ErrNotFound) || errors.Is(err, lock.ErrForbidden):
+		// Typical of a token scoped to the lock repository, while the
+		// holder ran in another one.
+		fmt.Fprintf(os.Stderr, "Warning: can't see run %d of the lock holder in %s (needs actions: read there), going by time\n", h.RunID, repo)
+		return "", false
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: failed to look up run %d of the lock holder: %v\n", h.RunID, err)
+		return "", false
+	case run == nil:
+		return fmt.Sprintf("run %d no longer exists", h.RunID), true
+	case run.Finished():
+		return fmt.Sprintf("run %d finished %s", h.RunID, run.Conclusion), true
+	}
+	return jobEnded(ctx, client, repo, h), true
+}
+
+// jobEnded explains why the holder's job no longer holds the lock although
+// its run is still in progress, e.g. because its runner crashed, or returns
+// "" if the job is in progress or can't be told apart. Only locks released
+// with their job are judged by it; others may be meant for a later job. The
+// job is recorded by its ID in the workflow, which the Actions API reports as
+// its name unless the job sets another name or belongs to a matrix; such jobs
+// are judged by their run alone.
+func jobEnded(ctx context.Context, client *lock.Client, repo string, h *lock.Holder) string {
+	if h.Job == "" || !h.JobScoped {
+		return ""
+	}
+	jobs, err := client.GetJobs(ctx, repo, h.RunID, h.RunAttempt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to look up the jobs of run %d of the lock holder: %v\n", h.RunID, err)
+		return ""
+	}
+
+	var match *lock.WorkflowJob
+	for i := range jobs {
+		if jobs[i].Name != h.Job {
+			continue
+		}
+		if match != nil {
+			return ""
+		}
+		match = &jobs[i]
+	}
+	if match == nil || !match.Finished() {
+		return ""
+	}
+	return fmt.Sprintf("job %s of run %d finished %s", h.Job, h.RunID, match.Conclusion)
+}
+
+// staleness explains why a lock is considered stale by time for log messages.
+func staleness(info *lock.Info, cfg *inputs.Config) string {
+	if exp := info.ExpiresAt(); !exp.IsZero() && time.Now().After(exp) {
+		return fmt.Sprintf("lease expired at %s", exp.Format(time.RFC3339))
+	}
+	idle := int(time.Since(info.RenewedAt()).Seconds())
+	return fmt.Sprintf("not renewed for %ds, threshold %ds", idle, cfg.StaleThreshold)
+}
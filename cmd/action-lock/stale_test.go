@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+)
+
+// newActionsServer serves the runs and jobs of owner/repo: run 1 is in
+// progress with a finished "deploy" job, run 2 has completed and run 3 no
+// longer exists. other/repo can't be seen.
+func newActionsServer(t *testing.T) *lock.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo":
+			_, _ = w.Write([]byte(`{"full_name":"owner/repo"}`))
+		case "/repos/owner/repo/actions/runs/1":
+			_, _ = w.Write([]byte(`{"status":"in_progress","conclusion":null}`))
+		case "/repos/owner/repo/actions/runs/1/jobs":
+			_, _ = w.Write([]byte(`{"jobs":[
+				{"name":"build","status":"in_progress","conclusion":null},
+				{"name":"deploy","status":"completed","conclusion":"failure"}
+			]}`))
+		case "/repos/owner/repo/actions/runs/2":
+			_, _ = w.Write([]byte(`{"status":"completed","conclusion":"success"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return lock.New("owner/repo", "t", lock.WithBaseURL(srv.URL))
+}
+
+func TestIsStale(t *testing.T) {
+	client := newActionsServer(t)
+	now := time.Now()
+	fresh := now.Add(-time.Minute)
+	expired := now.Add(-time.Hour)
+
+	for _, tt := range []struct {
+		name    string
+		policy  string
+		holder  lock.Holder
+		renewed time.Time
+		want    bool
+		why     string
+	}{
+		{"time fresh", "time", lock.Holder{RunID: 2}, fresh, false, "not renewed"},
+		{"time expired", "time", lock.Holder{RunID: 1}, expired, true, "not renewed"},
+		{"run in progress", "run-status", lock.Holder{RunID: 1, Job: "build", JobScoped: true}, expired, false, ""},
+		{"run completed", "run-status", lock.Holder{RunID: 2}, fresh, true, "run 2 finished success"},
+		{"run gone", "run-status", lock.Holder{RunID: 3}, fresh, true, "run 3 no longer exists"},
+		{"job finished", "run-status", lock.Holder{RunID: 1, Job: "deploy", JobScoped: true}, fresh, true, "job deploy of run 1 finished failure"},
+		{"job finished, held past it", "run-status", lock.Holder{RunID: 1, Job: "deploy"}, fresh, false, ""},
+		{"job unknown", "run-status", lock.Holder{RunID: 1, Job: "other", JobScoped: true}, fresh, false, ""},
+		{"no run, fresh", "run-status", lock.Holder{}, fresh, false, "not renewed"},
+		{"no run, expired", "run-status", lock.Holder{}, expired, true, "not renewed"},
+		{"invisible run", "run-status", lock.Holder{Repository: "other/repo", RunID: 2}, fresh, false, "not renewed"},
+		{"invisible run, expired", "run-status", lock.Holder{Repository: "other/repo", RunID: 2}, expired, true, "not renewed"},
+		{"both, expired", "both", lock.Holder{RunID: 1}, expired, true, "not renewed"},
+		{"both, run completed", "both", lock.Holder{RunID: 2}, fresh, true, "run 2 finished success"},
+		{"both, run in progress", "both", lock.Holder{RunID: 1}, fresh, false, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &inputs.Config{Repository: "owner/repo", StalePolicy: tt.policy, StaleThreshold: 600}
+			h := tt.holder
+			h.AcquiredAt = tt.renewed
+			h.RenewedAt = tt.renewed
+			info := &lock.Info{SHA: "abc123", Holder: &h, CommittedAt: tt.renewed}
+
+			stale, why := isStale(context.Background(), client, cfg, info)
+			if stale != tt.want {
+				t.Errorf("expected stale %t, got %t (%s)", tt.want, stale, why)
+			}
+			if tt.why == "" && why != "" || !strings.Contains(why, tt.why) {
+				t.Errorf("expected reason %q, got %q", tt.why, why)
+			}
+		})
+	}
+}
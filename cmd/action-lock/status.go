@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dnd-it/action-lock/internal/inputs"
+	"github.com/dnd-it/action-lock/internal/lock"
+	"github.com/dnd-it/action-lock/internal/outputs"
+)
+
+// status reports who holds the lock, without modifying it. Locks created by
+// older versions of the action only report their age and staleness.
+func status(ctx context.Context, client *lock.Client, cfg *inputs.Config) error {
+	info, err := client.Inspect(ctx, cfg.LockName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect lock %q: %w", cfg.LockName, err)
+	}
+
+	outputs.Set("locked", fmt.Sprintf("%t", info != nil))
+	if info == nil {
+		fmt.Printf("Lock %q is not held\n", cfg.LockName)
+		outputs.Set("holder_run_url", "")
+		outputs.Set("holder_actor", "")
+		outputs.Set("age_seconds", "")
+		outputs.Set("expires_at", "")
+		outputs.Set("is_stale", "false")
+		return nil
+	}
+
+	s := newLockStatus(cfg.LockName, info, cfg, time.Now())
+	fmt.Printf("Lock %q held by %s\n", cfg.LockName, describe(info))
+	outputs.Set("holder_run_url", s.RunURL)
+	outputs.Set("holder_actor", s.Actor)
+	outputs.Set("age_seconds", strconv.Itoa(s.AgeSeconds))
+	outputs.Set("expires_at", s.ExpiresAt)
+	outputs.Set("is_stale", fmt.Sprintf("%t", s.Stale))
+	return nil
+}
@@ -1,36 +1,184 @@
 package inputs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Action         string
-	LockName       string
+	Action string
+	// LockNames lists every lock to operate on, sorted so that all workflows
+	// acquire overlapping sets in the same order. LockName is the lock
+	// currently operated on; with several locks it joins their names.
+	LockNames []string
+	LockName  string
+	// EncodeLockName means the lock names were encoded with EncodeLockName.
+	EncodeLockName bool
 	Timeout        int
 	PollInterval   int
 	StaleThreshold int
+	StalePolicy    string
+	TTL            int
 	FailOnTimeout  bool
 	Token          string
+	// AppID and AppPrivateKey authenticate as a GitHub App instead of with
+	// Token, through the installation InstallationID or, if 0, the one on
+	// the repository.
+	AppID          string
+	AppPrivateKey  string
+	InstallationID int64
 	Repository     string
+	// LockRepository holds the lock refs, Repository by default.
+	LockRepository string
 	SHA            string
+	Reason         string
+	OwnerToken     string
+	Force          bool
+	// AutoRelease makes the post step release the locks in Held, which the
+	// acquire step recorded in the action state.
+	AutoRelease bool
+	Held        []string
+	// Owner identifies who the lock is held for. A lock already held by the
+	// same owner is re-entered instead of waited for.
+	Owner string
+
+	// MaxHolders > 1 turns the lock into a counting semaphore with that many
+	// slots. Slot selects the slot to release, or -1 for the one this run holds.
+	MaxHolders int
+	Slot       int
+	// Fair makes waiters acquire the lock in arrival order.
+	Fair bool
+	// Mode is "shared" or "exclusive" for a read-write lock, or empty for a
+	// plain lock.
+	Mode string
+
+	// HeartbeatInterval is how often the run action renews its lease.
+	HeartbeatInterval int
+	Command           string
+	// Args is the command of the run action given on the command line,
+	// executed directly instead of through the shell.
+	Args []string
+
+	// ServerURL is the GitHub web URL, used to link to workflow runs.
+	ServerURL string
+	// APIURL and GraphQLURL are the REST and GraphQL endpoints of the GitHub
+	// instance, e.g. https://ghes.example.com/api/v3 on GitHub Enterprise
+	// Server.
+	APIURL     string
+	GraphQLURL string
+
+	// Workflow run metadata recorded as the lock holder.
+	RunID      int64
+	RunAttempt int
+	Job        string
+	Workflow   string
+	Actor      string
+	PRNumber   int
 }
 
 func Parse() (*Config, error) {
+	return parse(nil)
+}
+
+// ParseArgs reads the configuration for the command-line form of the action:
+//
+//	action-lock <action> [--<input> <value>]... [-- <command> [<arg>]...]
+//
+// Flags name action inputs, with dashes or underscores, and take precedence
+// over the INPUT_* environment. The words after -- are the command of the run
+// action. The token defaults to $GITHUB_TOKEN.
+func ParseArgs(args []string) (*Config, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("usage: action-lock <action> [--<input> <value>]... [-- <command> [<arg>]...]")
+	}
+	_ = os.Setenv("INPUT_ACTION", args[0])
+
+	var command []string
+	rest := args[1:]
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == "--" {
+			command = rest[i+1:]
+			break
+		}
+		name, ok := strings.CutPrefix(rest[i], "--")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("unexpected argument %q", rest[i])
+		}
+		name, value, hasValue := strings.Cut(name, "=")
+		if !hasValue {
+			if i+1 == len(rest) {
+				return nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			i++
+			value = rest[i]
+		}
+		_ = os.Setenv("INPUT_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_")), value)
+	}
+
+	if os.Getenv("INPUT_TOKEN") == "" {
+		_ = os.Setenv("INPUT_TOKEN", os.Getenv("GITHUB_TOKEN"))
+	}
+	return parse(command)
+}
+
+func parse(args []string) (*Config, error) {
 	action := os.Getenv("INPUT_ACTION")
-	if action != "acquire" && action != "release" {
-		return nil, fmt.Errorf("invalid action %q: must be 'acquire' or 'release'", action)
+	switch action {
+	case "acquire", "release", "renew", "run", "status", "list", "reap":
+	default:
+		return nil, fmt.Errorf("invalid action %q: must be 'acquire', 'release', 'renew', 'run', 'status', 'list' or 'reap'", action)
 	}
 
-	lockName := os.Getenv("INPUT_LOCK_NAME")
-	if lockName == "" {
+	// For list and reap, lock_name is an optional prefix of the locks to
+	// operate on.
+	byPrefix := action == "list" || action == "reap"
+	lockNames := splitList(os.Getenv("INPUT_LOCK_NAME"))
+	if len(lockNames) == 0 && !byPrefix {
 		return nil, fmt.Errorf("lock_name is required")
 	}
 
+	// Lock names become ref names, so they must be valid as such unless
+	// they are encoded into one.
+	encodeLockName := boolEnv("INPUT_ENCODE_LOCK_NAME", false)
+	check := checkLockName
+	if byPrefix {
+		check = checkLockPrefix
+	}
+	for i, name := range lockNames {
+		if encodeLockName {
+			lockNames[i] = EncodeLockName(name)
+			continue
+		}
+		if err := check(name); err != nil {
+			return nil, fmt.Errorf("invalid lock_name %q: %w; set encode_lock_name to use any name", name, err)
+		}
+	}
+	slices.Sort(lockNames)
+	if name, parent, ok := nestedLockName(lockNames); ok {
+		return nil, fmt.Errorf("lock_name %q can't be taken together with %q: a lock can't be nested under another", name, parent)
+	}
+
+	// A GitHub App authenticates with installation tokens minted from its
+	// private key instead of the token.
+	appID := os.Getenv("INPUT_APP_ID")
+	appPrivateKey := os.Getenv("INPUT_APP_PRIVATE_KEY")
+	if (appID == "") != (appPrivateKey == "") {
+		return nil, fmt.Errorf("app_id and app_private_key must be given together")
+	}
+	installationID := int64(intEnv("INPUT_INSTALLATION_ID", 0))
+	if installationID != 0 && appID == "" {
+		return nil, fmt.Errorf("installation_id requires app_id and app_private_key")
+	}
+
 	token := os.Getenv("INPUT_TOKEN")
-	if token == "" {
+	if token == "" && appID == "" {
 		return nil, fmt.Errorf("token is required")
 	}
 
@@ -39,29 +187,265 @@ func Parse() (*Config, error) {
 		return nil, fmt.Errorf("GITHUB_REPOSITORY not set")
 	}
 
+	// Locks may live in another repository shared by several repositories'
+	// workflows; the holder is still recorded as the workflow's repository.
+	lockRepo := os.Getenv("INPUT_LOCK_REPOSITORY")
+	if lockRepo == "" {
+		lockRepo = repo
+	}
+	if org, name, ok := strings.Cut(lockRepo, "/"); !ok || org == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid lock_repository %q: must be <owner>/<repo>", lockRepo)
+	}
+
 	sha := os.Getenv("GITHUB_SHA")
 	if sha == "" {
 		return nil, fmt.Errorf("GITHUB_SHA not set")
 	}
 
+	maxHolders := intEnv("INPUT_MAX_HOLDERS", 1)
+	if maxHolders < 1 {
+		return nil, fmt.Errorf("invalid max_holders %d: must be at least 1", maxHolders)
+	}
+
+	slot := intEnv("INPUT_SLOT", -1)
+	if slot >= maxHolders {
+		return nil, fmt.Errorf("invalid slot %d: must be less than max_holders (%d)", slot, maxHolders)
+	}
+	if (action == "status" || byPrefix) && len(lockNames) > 1 {
+		return nil, fmt.Errorf("action '%s' takes a single lock_name", action)
+	}
+	if slot >= 0 && len(lockNames) > 1 {
+		return nil, fmt.Errorf("slot cannot be combined with several lock names")
+	}
+
+	fair := boolEnv("INPUT_FAIR", false)
+
+	mode := os.Getenv("INPUT_MODE")
+	switch {
+	case mode != "" && mode != "shared" && mode != "exclusive":
+		return nil, fmt.Errorf("invalid mode %q: must be 'shared' or 'exclusive'", mode)
+	case mode != "" && maxHolders > 1:
+		return nil, fmt.Errorf("mode cannot be combined with max_holders")
+	case mode != "" && fair:
+		return nil, fmt.Errorf("mode cannot be combined with fair")
+	}
+
+	stalePolicy := os.Getenv("INPUT_STALE_POLICY")
+	switch stalePolicy {
+	case "":
+		stalePolicy = "time"
+	case "time", "run-status", "both":
+	default:
+		return nil, fmt.Errorf("invalid stale_policy %q: must be 'time', 'run-status' or 'both'", stalePolicy)
+	}
+
+	command := os.Getenv("INPUT_COMMAND")
+	if action == "run" && command == "" && len(args) == 0 {
+		return nil, fmt.Errorf("command is required for action 'run'")
+	}
+
+	runID := int64(intEnv("GITHUB_RUN_ID", 0))
+	force := boolEnv("INPUT_FORCE", false)
+	ownerToken := ownerToken(lockNames)
+	// Without a run to match the holder against, e.g. on the command line,
+	// only the owner token tells whose lock it is.
+	if (action == "release" && !force || action == "renew") && runID == 0 && ownerToken == "" {
+		return nil, fmt.Errorf("owner_token is required for action '%s' outside a workflow run", action)
+	}
+	pr := prNumber()
 	timeout := intEnv("INPUT_TIMEOUT", 300)
 	pollInterval := intEnv("INPUT_POLL_INTERVAL", 10)
 	staleThreshold := intEnv("INPUT_STALE_THRESHOLD", 600)
+	ttl := intEnv("INPUT_TTL", 0)
 	failOnTimeout := boolEnv("INPUT_FAIL_ON_TIMEOUT", true)
 
+	apiURL, graphqlURL, err := apiURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	// Renew well within the lease so a single failed renewal doesn't let
+	// waiters take over.
+	heartbeatInterval := intEnv("INPUT_HEARTBEAT_INTERVAL", 0)
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = 60
+		if lease := shortestPositive(ttl, staleThreshold); lease > 0 {
+			heartbeatInterval = max(lease/3, 1)
+		}
+	}
+
 	return &Config{
-		Action:         action,
-		LockName:       lockName,
-		Timeout:        timeout,
-		PollInterval:   pollInterval,
-		StaleThreshold: staleThreshold,
-		FailOnTimeout:  failOnTimeout,
-		Token:          token,
-		Repository:     repo,
-		SHA:            sha,
+		Action:            action,
+		LockNames:         lockNames,
+		LockName:          strings.Join(lockNames, ","),
+		EncodeLockName:    encodeLockName,
+		Timeout:           timeout,
+		PollInterval:      pollInterval,
+		StaleThreshold:    staleThreshold,
+		StalePolicy:       stalePolicy,
+		TTL:               ttl,
+		FailOnTimeout:     failOnTimeout,
+		MaxHolders:        maxHolders,
+		Slot:              slot,
+		Fair:              fair,
+		Mode:              mode,
+		HeartbeatInterval: heartbeatInterval,
+		Command:           command,
+		Args:              args,
+		Token:             token,
+		AppID:             appID,
+		AppPrivateKey:     appPrivateKey,
+		InstallationID:    installationID,
+		Repository:        repo,
+		LockRepository:    lockRepo,
+		SHA:               sha,
+		Reason:            os.Getenv("INPUT_REASON"),
+		OwnerToken:        ownerToken,
+		Force:             force,
+		AutoRelease:       boolEnv("INPUT_AUTO_RELEASE", true),
+		Held:              splitList(os.Getenv("STATE_locks")),
+		Owner:             os.Getenv("INPUT_OWNER"),
+		ServerURL:         serverURL(),
+		APIURL:            apiURL,
+		GraphQLURL:        graphqlURL,
+		RunID:             runID,
+		RunAttempt:        intEnv("GITHUB_RUN_ATTEMPT", 0),
+		Job:               os.Getenv("GITHUB_JOB"),
+		Workflow:          os.Getenv("GITHUB_WORKFLOW"),
+		Actor:             os.Getenv("GITHUB_ACTOR"),
+		PRNumber:          pr,
 	}, nil
 }
 
+// IsPost reports whether the action runs as its post step, going by the state
+// the main step saved.
+func IsPost() bool {
+	return os.Getenv("STATE_isPost") == "true"
+}
+
+// ownerToken returns the owner token passed in explicitly, falling back to
+// the one saved in the action state by an acquire step of the same action, and
+// then to the one an earlier acquire step of the job exported for all of
+// lockNames.
+func ownerToken(lockNames []string) string {
+	if v := os.Getenv("INPUT_OWNER_TOKEN"); v != "" {
+		return v
+	}
+	if v := os.Getenv("STATE_owner_token"); v != "" {
+		return v
+	}
+	token := ""
+	for _, name := range lockNames {
+		v := os.Getenv(OwnerTokenVar(name))
+		if v == "" || token != "" && v != token {
+			return ""
+		}
+		token = v
+	}
+	return token
+}
+
+// OwnerTokenVar returns the environment variable through which an acquire
+// step hands the owner token of lockName to the later steps of its job. Lock
+// names may contain characters that variable names can't, so the name is
+// hashed.
+func OwnerTokenVar(lockName string) string {
+	sum := sha256.Sum256([]byte(lockName))
+	return "ACTION_LOCK_OWNER_TOKEN_" + hex.EncodeToString(sum[:8])
+}
+
+// serverURL returns the web URL of the GitHub instance running the workflow.
+func serverURL() string {
+	if v := os.Getenv("GITHUB_SERVER_URL"); v != "" {
+		return v
+	}
+	return "https://github.com"
+}
+
+// apiURLs returns the REST and GraphQL endpoints of the GitHub instance: the
+// api_url input or $GITHUB_API_URL, and $GITHUB_GRAPHQL_URL unless api_url
+// points elsewhere. A bare GitHub Enterprise Server URL gets the /api/v3
+// prefix of its REST API.
+func apiURLs() (string, string, error) {
+	api := strings.TrimSuffix(os.Getenv("INPUT_API_URL"), "/")
+	graphql := ""
+	if api == "" {
+		api = strings.TrimSuffix(os.Getenv("GITHUB_API_URL"), "/")
+		graphql = os.Getenv("GITHUB_GRAPHQL_URL")
+	}
+	if api == "" {
+		api = "https://api.github.com"
+	}
+
+	u, err := url.Parse(api)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", "", fmt.Errorf("invalid api_url %q: must be an http or https URL", api)
+	}
+	// GitHub.com and GHE.com serve the API on an api. host, Enterprise
+	// Server under /api/v3 of its own host.
+	if u.Path == "" && !strings.HasPrefix(u.Host, "api.") {
+		api += "/api/v3"
+	}
+
+	if graphql == "" {
+		if base, ok := strings.CutSuffix(api, "/api/v3"); ok {
+			graphql = base + "/api/graphql"
+		} else {
+			graphql = api + "/graphql"
+		}
+	}
+	return api, graphql, nil
+}
+
+// prNumber returns the pull request number from the triggering event payload,
+// or 0 when the workflow was not triggered by a pull request.
+func prNumber() int {
+	path := os.Getenv("GITHUB_EVENT_PATH")
+	if path == "" {
+		return 0
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	var event struct {
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return 0
+	}
+	return event.PullRequest.Number
+}
+
+// splitList splits a list input on commas and newlines, dropping blanks and
+// duplicates, and returns it sorted.
+func splitList(v string) []string {
+	fields := strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '\n' })
+	var items []string
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			items = append(items, f)
+		}
+	}
+	slices.Sort(items)
+	return slices.Compact(items)
+}
+
+// shortestPositive returns the smaller of two durations, ignoring values of
+// zero or less, which mean "unset". Returns 0 if neither is set.
+func shortestPositive(a, b int) int {
+	switch {
+	case a <= 0:
+		return max(b, 0)
+	case b <= 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
+
 func boolEnv(key string, defaultVal bool) bool {
 	v := os.Getenv(key)
 	if v == "" {
@@ -0,0 +1,129 @@
+package inputs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxEncodedLen bounds the length of an encoded lock name, leaving room for
+// the suffixes of slot, reader and writer refs within the 255 bytes a file
+// name may take where git stores refs as files.
+const maxEncodedLen = 200
+
+// checkLockName reports why name can't be used as is below refs/locks/,
+// following the rules of git check-ref-format, or nil if it can.
+func checkLockName(name string) error {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c == 0x7f {
+			return fmt.Errorf("contains control character %#02x", c)
+		}
+	}
+	if i := strings.IndexAny(name, " ~^:?*[\\"); i >= 0 {
+		return fmt.Errorf("contains %q", name[i])
+	}
+	switch {
+	case strings.Contains(name, ".."):
+		return fmt.Errorf(`contains ".."`)
+	case strings.Contains(name, "@{"):
+		return fmt.Errorf(`contains "@{"`)
+	case strings.HasSuffix(name, "."):
+		return fmt.Errorf(`ends with "."`)
+	}
+	for _, part := range strings.Split(name, "/") {
+		switch {
+		case part == "":
+			return fmt.Errorf(`has an empty path component (leading, trailing or double "/")`)
+		case strings.HasPrefix(part, "."):
+			return fmt.Errorf(`has a path component starting with "."`)
+		case strings.HasSuffix(part, ".lock"):
+			return fmt.Errorf(`has a path component ending with ".lock"`)
+		}
+	}
+	return nil
+}
+
+// checkLockPrefix reports why prefix can't start any lock name. A prefix may
+// end in the middle of a name or component, so it is checked as the start of
+// one.
+func checkLockPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	return checkLockName(prefix + "x")
+}
+
+// EncodeLockName turns any name into one that is safe as a ref name. Bytes
+// other than letters, digits, "_" and "-" are percent-encoded, "/" included,
+// as are dots where git forbids them; the rest of the dots are kept, so that
+// e.g. "v1.2" stays readable. Names whose encoding exceeds maxEncodedLen are
+// shortened and suffixed with "+" and a hash of the name, and can't be
+// decoded.
+func EncodeLockName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '-':
+			b.WriteByte(c)
+		case c == '.' && i > 0 && name[i-1] != '.' && i < len(name)-1 && name[i:] != ".lock":
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	encoded := b.String()
+	if len(encoded) <= maxEncodedLen {
+		return encoded
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:16])
+	short := encoded[:maxEncodedLen-len(hash)-1]
+	// Don't cut an escape in half.
+	if i := strings.LastIndexByte(short, '%'); i >= len(short)-2 {
+		short = short[:i]
+	}
+	return short + "+" + hash
+}
+
+// DecodeLockName reverses EncodeLockName. It reports false if name isn't an
+// encoded name or was shortened to a hash.
+func DecodeLockName(name string) (string, bool) {
+	if strings.Contains(name, "+") {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", false
+		}
+		v, err := hex.DecodeString(name[i+1 : i+3])
+		if err != nil {
+			return "", false
+		}
+		b.Write(v)
+		i += 2
+	}
+	return b.String(), true
+}
+
+// nestedLockName returns a lock name among names that another one is nested
+// under, such as "a/b" under "a", and the name it is nested under. Git can't
+// hold both refs/locks/a and refs/locks/a/b, so such locks can't be taken
+// together.
+func nestedLockName(names []string) (string, string, bool) {
+	for _, parent := range names {
+		for _, name := range names {
+			if strings.HasPrefix(name, parent+"/") {
+				return name, parent, true
+			}
+		}
+	}
+	return "", "", false
+}
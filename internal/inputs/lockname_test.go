@@ -0,0 +1,68 @@
+package inputs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckLockName(t *testing.T) {
+	for _, name := range []string{"deploy", "team/db", "v1.2", "50%#off", "a.b/c-d_e", "@"} {
+		if err := checkLockName(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestEncodeLockName(t *testing.T) {
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{"deploy", "deploy"},
+		{"v1.2 / east", "v1.2%20%2F%20east"},
+		{".hidden", "%2Ehidden"},
+		{"a..b", "a.%2Eb"},
+		{"v1.", "v1%2E"},
+		{"db.lock", "db%2Elock"},
+		{"50%", "50%25"},
+		{"a+b", "a%2Bb"},
+		{"ü", "%C3%BC"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EncodeLockName(tt.name)
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			if err := checkLockName(got); err != nil {
+				t.Errorf("encoded name %q is invalid: %v", got, err)
+			}
+			if decoded, ok := DecodeLockName(got); !ok || decoded != tt.name {
+				t.Errorf("expected %q to decode to %q, got %q, %t", got, tt.name, decoded, ok)
+			}
+		})
+	}
+}
+
+func TestEncodeLockName_Long(t *testing.T) {
+	long := strings.Repeat("a b ", 100)
+	got := EncodeLockName(long)
+	if len(got) > maxEncodedLen {
+		t.Errorf("expected at most %d bytes, got %d", maxEncodedLen, len(got))
+	}
+	if err := checkLockName(got); err != nil {
+		t.Errorf("encoded name %q is invalid: %v", got, err)
+	}
+	if EncodeLockName(long+"x") == got {
+		t.Error("expected names sharing a prefix to be told apart")
+	}
+	if _, ok := DecodeLockName(got); ok {
+		t.Error("expected a hashed name not to decode")
+	}
+}
+
+func TestDecodeLockName_Invalid(t *testing.T) {
+	for _, name := range []string{"50%", "50%2", "50%zz"} {
+		if _, ok := DecodeLockName(name); ok {
+			t.Errorf("%q: expected decoding to fail", name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package lock
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies the token API requests are authenticated with.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token that never changes, e.g. GITHUB_TOKEN.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// WithTokenSource authenticates the client's requests with tokens from ts
+// instead of the token passed to New.
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *Client) { c.auth = ts }
+}
+
+// refreshMargin is how long before its expiry an installation token is
+// replaced, so that a request never goes out with a token about to expire.
+const refreshMargin = 5 * time.Minute
+
+// AppTokenSource authenticates as a GitHub App installation. It signs a JWT
+// with the app's private key, looks up the installation on the repository
+// unless its ID is given, and exchanges the JWT for an installation token,
+// which it renews shortly before it expires.
+type AppTokenSource struct {
+	baseURL        string
+	appID          string
+	key            *rsa.PrivateKey
+	repo           string
+	http           *http.Client
+	installationID int64
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewAppTokenSource returns a token source for the installation of the app
+// appID on repo, or for installationID if it isn't 0. privateKey is the PEM
+// encoded private key of the app; escaped newlines are accepted too.
+func NewAppTokenSource(baseURL, appID, privateKey string, installationID int64, repo string) (*AppTokenSource, error) {
+	key, err := parsePrivateKey(strings.ReplaceAll(privateKey, `\n`, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	return &AppTokenSource{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		appID:          appID,
+		key:            key,
+		repo:           repo,
+		http:           &http.Client{Transport: newRetryTransport(&rateLimits{}), Timeout: 5 * time.Minute},
+		installationID: installationID,
+	}, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid app private key: no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid app private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid app private key: not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Token returns an installation token, minting a new one if there is none
+// yet or the current one expires within refreshMargin.
+func (s *AppTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expires) > refreshMargin {
+		return s.token, nil
+	}
+
+	jwt, err := s.jwt(time.Now())
+	if err != nil {
+		return "", err
+	}
+	if s.installationID == 0 {
+		var installation struct {
+			ID int64 `json:"id"`
+		}
+		err := s.call(ctx, "GET", fmt.Sprintf("/repos/%s/installation", s.repo), jwt, http.StatusOK, &installation)
+		if err != nil {
+			return "", fmt.Errorf("find app installation on %s: %w", s.repo, err)
+		}
+		s.installationID = installation.ID
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = s.call(ctx, "POST", fmt.Sprintf("/app/installations/%d/access_tokens", s.installationID), jwt, http.StatusCreated, &result)
+	if err != nil {
+		return "", fmt.Errorf("create installation token: %w", err)
+	}
+	s.token, s.expires = result.Token, result.ExpiresAt
+	return s.token, nil
+}
+
+// jwt returns a JWT identifying the app, signed with its private key. It is
+// backdated a minute against clock drift and valid for ten minutes in all,
+// the longest GitHub accepts.
+func (s *AppTokenSource) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": s.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// call makes an API request authenticated as the app and decodes the
+// response into result. Minting another token is harmless, so the request
+// may be retried.
+func (s *AppTokenSource) call(ctx context.Context, method, path, jwt string, want int, result any) error {
+	req, err := http.NewRequestWithContext(idempotent(ctx), method, s.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != want {
+		return newAPIError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,206 @@
+package lock
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeApp stands in for the GitHub App endpoints, checking the JWT of every
+// request against the app's public key.
+type fakeApp struct {
+	t         *testing.T
+	key       *rsa.PrivateKey
+	lifetime  time.Duration
+	lookups   atomic.Int32
+	mints     atomic.Int32
+	installed int64
+}
+
+func newFakeApp(t *testing.T) (*fakeApp, *httptest.Server) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	app := &fakeApp{t: t, key: key, lifetime: time.Hour, installed: 42}
+	srv := httptest.NewServer(app)
+	t.Cleanup(srv.Close)
+	return app, srv
+}
+
+func (app *fakeApp) pem(pkcs8 bool) string {
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(app.key)
+		if err != nil {
+			app.t.Fatalf("marshal key: %v", err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(app.key)}))
+}
+
+func (app *fakeApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !app.verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/repos/owner/repo/installation":
+		app.lookups.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": app.installed})
+	case r.Method == "POST" && r.URL.Path == "/app/installations/42/access_tokens":
+		n := app.mints.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("ghs_%d", n),
+			"expires_at": time.Now().Add(app.lifetime).UTC().Format(time.RFC3339),
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// verify checks that jwt is signed by the app and issued by app 123.
+func (app *fakeApp) verify(jwt string) bool {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(&app.key.PublicKey, crypto.SHA256, digest[:], sig) != nil {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	now := time.Now().Unix()
+	return claims.Iss == "123" && claims.Iat <= now && claims.Exp > now && claims.Exp-claims.Iat <= 600
+}
+
+func TestAppTokenSource(t *testing.T) {
+	app, srv := newFakeApp(t)
+	ts, err := NewAppTokenSource(srv.URL, "123", app.pem(false), 0, "owner/repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 2 {
+		token, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != "ghs_1" {
+			t.Errorf("expected ghs_1, got %q", token)
+		}
+	}
+	if app.lookups.Load() != 1 || app.mints.Load() != 1 {
+		t.Errorf("expected a single lookup and token, got %d and %d", app.lookups.Load(), app.mints.Load())
+	}
+}
+
+func TestAppTokenSource_RefreshesBeforeExpiry(t *testing.T) {
+	app, srv := newFakeApp(t)
+	app.lifetime = 2 * time.Minute
+	ts, err := NewAppTokenSource(srv.URL, "123", app.pem(false), 0, "owner/repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{"ghs_1", "ghs_2"} {
+		token, err := ts.Token(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != want {
+			t.Errorf("expected %s, got %q", want, token)
+		}
+	}
+	if n := app.lookups.Load(); n != 1 {
+		t.Errorf("expected the installation to be looked up once, got %d", n)
+	}
+}
+
+func TestAppTokenSource_InstallationID(t *testing.T) {
+	app, srv := newFakeApp(t)
+	// A PKCS#8 key with escaped newlines, as some secret stores keep it.
+	key := strings.ReplaceAll(app.pem(true), "\n", `\n`)
+	ts, err := NewAppTokenSource(srv.URL, "123", key, 42, "owner/repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := ts.Token(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := app.lookups.Load(); n != 0 {
+		t.Errorf("expected no installation lookup, got %d", n)
+	}
+}
+
+func TestAppTokenSource_NotInstalled(t *testing.T) {
+	app, srv := newFakeApp(t)
+	ts, err := NewAppTokenSource(srv.URL, "123", app.pem(false), 0, "other/repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = ts.Token(ctx)
+	if err == nil || !strings.Contains(err.Error(), "other/repo") {
+		t.Errorf("expected installation lookup error, got %v", err)
+	}
+}
+
+func TestNewAppTokenSource_InvalidKey(t *testing.T) {
+	if _, err := NewAppTokenSource("https://api.github.com", "123", "not a key", 0, "owner/repo"); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
+
+func TestWithTokenSource(t *testing.T) {
+	app, appSrv := newFakeApp(t)
+	ts, err := NewAppTokenSource(appSrv.URL, "123", app.pem(false), 0, "owner/repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer ghs_1" {
+			t.Errorf("unexpected authorization %q", got)
+		}
+		_, _ = w.Write([]byte(`{"object":{"sha":"abc123"}}`))
+	}))
+	defer srv.Close()
+
+	c := New("owner/repo", "", WithBaseURL(srv.URL), WithTokenSource(ts))
+	if _, err := c.getRefSHA(ctx, "locks/deploy"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Errors returned by the client, to be tested for with errors.Is. Failed API
+// requests are reported as an *APIError, or a *RateLimitError, matching one
+// of them.
+var (
+	// ErrLockHeld means the lock is held by someone else. Acquire reports a
+	// held lock by returning false; errors that stem from contention, like
+	// ErrNotOwner, match it.
+	ErrLockHeld = errors.New("lock is held")
+	// ErrNotFound means the repository, ref or object doesn't exist, or the
+	// token may not see it.
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden means the token is invalid or lacks a permission, e.g.
+	// contents:write on the lock repository.
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited means GitHub rejected the request for exceeding a rate
+	// limit.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrInvalidRef means GitHub rejected a ref operation as invalid, e.g. a
+	// malformed lock name or one nested under another lock.
+	ErrInvalidRef = errors.New("invalid ref")
+	// ErrInvalidSHA means GitHub rejected a ref operation because the commit
+	// it points the ref at doesn't exist in the repository.
+	ErrInvalidSHA = errors.New("invalid sha")
+	// ErrTransient means the request failed in a way that may not recur: a
+	// network error or a server error that persisted through the retries.
+	ErrTransient = errors.New("transient error")
+)
+
+// ErrNotOwner is returned by Release and Renew when the lock is held by
+// someone else.
+var ErrNotOwner = fmt.Errorf("%w by another owner", ErrLockHeld)
+
+// APIError is a GitHub API request that failed with an unexpected status.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// RequestID identifies the request to GitHub support
+	// (X-GitHub-Request-Id).
+	RequestID string
+	// Message is the message of the response, or its body if it has none.
+	Message string
+
+	kind error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: status %d", e.Method, e.URL, e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request ID " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Unwrap returns the client error the status stands for, if any.
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// newAPIError describes the failed request of resp, reading its body.
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	var result struct {
+		Message string `json:"message"`
+	}
+	msg := string(body)
+	if json.Unmarshal(body, &result) == nil && result.Message != "" {
+		msg = result.Message
+	}
+
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-GitHub-Request-Id"),
+		Message:    msg,
+	}
+	if req := resp.Request; req != nil {
+		e.Method, e.URL = req.Method, req.URL.Redacted()
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		e.kind = ErrForbidden
+	case resp.StatusCode == http.StatusNotFound:
+		e.kind = ErrNotFound
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		e.kind = ErrInvalidRef
+	case resp.StatusCode >= 500:
+		e.kind = ErrTransient
+	}
+	return e
+}
+
+// do sends req, marking errors that leave the request's outcome unknown as
+// transient.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	var rle *RateLimitError
+	if err == nil || errors.As(err, &rle) || req.Context().Err() != nil {
+		return resp, err
+	}
+	return nil, fmt.Errorf("%w: %w", ErrTransient, err)
+}
@@ -0,0 +1,109 @@
+package lock
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{"object":{"sha":"abc123"}}`))
+			return
+		}
+		w.Header().Set("X-GitHub-Request-Id", "ABCD:1234")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"Resource not accessible by integration","documentation_url":"https://docs.github.com"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	err := c.ForceRelease(ctx, "deploy")
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected APIError, got %T", err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden || apiErr.RequestID != "ABCD:1234" || apiErr.Method != "DELETE" {
+		t.Errorf("unexpected error details: %+v", apiErr)
+	}
+	for _, want := range []string{"DELETE", "/git/refs/locks/deploy", "403", "Resource not accessible by integration", "ABCD:1234"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error message %q", want, err.Error())
+		}
+	}
+}
+
+func TestAPIError_Kinds(t *testing.T) {
+	for status, want := range map[int]error{
+		http.StatusUnauthorized:        ErrForbidden,
+		http.StatusForbidden:           ErrForbidden,
+		http.StatusNotFound:            ErrNotFound,
+		http.StatusUnprocessableEntity: ErrInvalidRef,
+		http.StatusInternalServerError: ErrTransient,
+		http.StatusBadGateway:          ErrTransient,
+		http.StatusConflict:            nil,
+	} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			c := newTestClient(srv.URL)
+			_, err := c.createObject(ctx, "/repos/owner/repo/git/trees", map[string]any{})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected APIError, got %v", err)
+			}
+			if errors.Unwrap(apiErr) != want {
+				t.Errorf("expected %v, got %v", want, errors.Unwrap(apiErr))
+			}
+		})
+	}
+}
+
+func TestDo_NetworkErrorIsTransient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	c := newTestClient(srv.URL)
+	_, err := c.getRefSHA(ctx, "locks/deploy")
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("expected ErrTransient, got %v", err)
+	}
+}
+
+func TestRateLimitError_IsRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-GitHub-Request-Id", "ABCD:1234")
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	_, err := c.getRefSHA(ctx, "locks/deploy")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if errors.Is(err, ErrForbidden) || errors.Is(err, ErrTransient) {
+		t.Errorf("expected rate limiting to be told apart, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "ABCD:1234") {
+		t.Errorf("expected the request ID in %q", err.Error())
+	}
+}
+
+func TestErrNotOwner_IsLockHeld(t *testing.T) {
+	if !errors.Is(ErrNotOwner, ErrLockHeld) {
+		t.Error("expected ErrNotOwner to match ErrLockHeld")
+	}
+}
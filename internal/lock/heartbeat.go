@@ -0,0 +1,60 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Heartbeat renews a lock in the background so that waiters judging
+// staleness by the last renewal don't take over a lock whose holder is still
+// working.
+type Heartbeat struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartHeartbeat renews the lock held under token every interval until Stop
+// is called or ctx is cancelled. Failed renewals are reported to onError; once
+// the lock has been lost (ErrNotOwner) renewal stops.
+func (c *Client) StartHeartbeat(ctx context.Context, lockName, token string, interval time.Duration, onError func(error)) *Heartbeat {
+	hb := &Heartbeat{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(hb.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hb.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A renewal waiting out a rate limit past the next tick
+				// would only delay it; fail and try again then.
+				err := c.Renew(WithWaitDeadline(ctx, time.Now().Add(interval)), lockName, token)
+				if err == nil {
+					continue
+				}
+				onError(err)
+				if errors.Is(err, ErrNotOwner) {
+					return
+				}
+			}
+		}
+	}()
+
+	return hb
+}
+
+// Stop ends renewal and waits for an in-flight renewal to finish.
+func (hb *Heartbeat) Stop() {
+	close(hb.stop)
+	<-hb.done
+}
@@ -0,0 +1,84 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat_Renews(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gh.mu.Lock()
+	before := gh.refs["locks/deploy"]
+	gh.mu.Unlock()
+
+	hb := c.StartHeartbeat(ctx, "deploy", "mine", 10*time.Millisecond, func(err error) {
+		t.Errorf("unexpected error: %v", err)
+	})
+	time.Sleep(50 * time.Millisecond)
+	hb.Stop()
+
+	info, err := c.Inspect(ctx, "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.SHA == before {
+		t.Error("expected lock to be renewed")
+	}
+	if info.Holder.Token != "mine" {
+		t.Errorf("expected lock to stay ours, got %+v", info.Holder)
+	}
+}
+
+func TestHeartbeat_StopsWhenLost(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "theirs"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gh.mu.Lock()
+	sha := gh.refs["locks/deploy"]
+	gh.mu.Unlock()
+
+	errs := make(chan error, 10)
+	hb := c.StartHeartbeat(ctx, "deploy", "mine", 10*time.Millisecond, func(err error) {
+		errs <- err
+	})
+	time.Sleep(50 * time.Millisecond)
+	hb.Stop()
+
+	if len(errs) != 1 {
+		t.Fatalf("expected a single error before renewal stops, got %d", len(errs))
+	}
+	if err := <-errs; !errors.Is(err, ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner, got %v", err)
+	}
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	if gh.refs["locks/deploy"] != sha {
+		t.Error("expected lock ref to be untouched")
+	}
+}
+
+func TestHeartbeat_StopsWhenCancelled(t *testing.T) {
+	_, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hbCtx, cancel := context.WithCancel(ctx)
+	hb := c.StartHeartbeat(hbCtx, "deploy", "mine", time.Hour, func(err error) {
+		t.Errorf("unexpected error: %v", err)
+	})
+	cancel()
+
+	select {
+	case <-hb.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected heartbeat to stop on cancellation")
+	}
+	hb.Stop()
+}
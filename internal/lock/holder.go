@@ -0,0 +1,143 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// messagePrefix starts the subject line of every lock commit.
+const messagePrefix = "action-lock: "
+
+// Holder identifies the workflow run holding a lock. It is stored as JSON in
+// the message of the lock commit so anyone reading the ref can tell who holds
+// the lock and since when.
+type Holder struct {
+	// Token identifies the acquisition. Only a caller presenting the same
+	// token may release the lock.
+	Token string `json:"token,omitempty"`
+	// Owner identifies who the lock is held for across workflow runs, e.g.
+	// a pull request. A lock held by the same owner may be re-entered.
+	Owner      string    `json:"owner,omitempty"`
+	Repository string    `json:"repository,omitempty"`
+	RunID      int64     `json:"run_id,omitempty"`
+	RunAttempt int       `json:"run_attempt,omitempty"`
+	Job        string    `json:"job,omitempty"`
+	Workflow   string    `json:"workflow,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	PR         int       `json:"pr,omitempty"`
+	SHA        string    `json:"sha,omitempty"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at"`
+	// TTL is the lease duration in seconds declared by the holder. The lock
+	// expires TTL seconds after the last renewal; 0 means no declared expiry.
+	TTL    int    `json:"ttl,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Reentries counts how often the owner re-entered the lock since it was
+	// first acquired.
+	Reentries int `json:"reentries,omitempty"`
+	// JobScoped means the holder releases the lock by the end of its job, so
+	// a lock that outlives the job was left behind by a crash.
+	JobScoped bool `json:"job_scoped,omitempty"`
+}
+
+// RunURL links to the holder's workflow run on serverURL (e.g.
+// https://github.com), or returns "" if the run is unknown.
+func (h *Holder) RunURL(serverURL string) string {
+	if h.Repository == "" || h.RunID == 0 {
+		return ""
+	}
+	url := fmt.Sprintf("%s/%s/actions/runs/%d", strings.TrimSuffix(serverURL, "/"), h.Repository, h.RunID)
+	if h.RunAttempt > 1 {
+		url += fmt.Sprintf("/attempts/%d", h.RunAttempt)
+	}
+	return url
+}
+
+// NewToken returns a random owner token for a new acquisition.
+func NewToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Info describes the commit a lock ref currently points at.
+type Info struct {
+	SHA string
+	// Holder is nil for locks created by older versions of the action, which
+	// pointed the ref at the workflow's commit instead of a lock commit.
+	Holder      *Holder
+	CommittedAt time.Time
+}
+
+// AcquiredAt returns when the lock was taken. Locks without holder metadata
+// fall back to the commit date.
+func (i *Info) AcquiredAt() time.Time {
+	if i.Holder != nil && !i.Holder.AcquiredAt.IsZero() {
+		return i.Holder.AcquiredAt
+	}
+	return i.CommittedAt
+}
+
+// RenewedAt returns when the holder last renewed its lease, which is the
+// acquisition time for locks that were never renewed.
+func (i *Info) RenewedAt() time.Time {
+	if i.Holder != nil && !i.Holder.RenewedAt.IsZero() {
+		return i.Holder.RenewedAt
+	}
+	return i.AcquiredAt()
+}
+
+// ExpiresAt returns when the holder's declared lease runs out, or the zero
+// time if the holder declared no TTL.
+func (i *Info) ExpiresAt() time.Time {
+	if i.Holder == nil || i.Holder.TTL <= 0 {
+		return time.Time{}
+	}
+	return i.RenewedAt().Add(time.Duration(i.Holder.TTL) * time.Second)
+}
+
+// OwnedBy reports whether the lock is held for owner. An empty owner owns
+// nothing.
+func (i *Info) OwnedBy(owner string) bool {
+	return owner != "" && i.Holder != nil && i.Holder.Owner == owner
+}
+
+// Stale reports whether the lock may be taken over at now. A lock is stale
+// once its declared lease has expired, so every waiter agrees on when it
+// expires. maxIdle, if positive, is an upper bound on the time since the last
+// renewal that applies whether or not the holder declared a TTL.
+func (i *Info) Stale(now time.Time, maxIdle time.Duration) bool {
+	if exp := i.ExpiresAt(); !exp.IsZero() && now.After(exp) {
+		return true
+	}
+	return maxIdle > 0 && now.Sub(i.RenewedAt()) > maxIdle
+}
+
+func commitMessage(lockName string, h *Holder) (string, error) {
+	body, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s\n\n%s\n", messagePrefix, lockName, body), nil
+}
+
+// parseMessage extracts holder metadata from a lock commit message. It
+// returns nil for commits that were not created by action-lock.
+func parseMessage(msg string) *Holder {
+	if !strings.HasPrefix(msg, messagePrefix) {
+		return nil
+	}
+	_, body, ok := strings.Cut(msg, "\n\n")
+	if !ok {
+		return nil
+	}
+	var h Holder
+	if err := json.Unmarshal([]byte(body), &h); err != nil {
+		return nil
+	}
+	return &h
+}
@@ -0,0 +1,169 @@
+package lock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommitMessage_RoundTrip(t *testing.T) {
+	in := &Holder{
+		Token:      NewToken(),
+		Owner:      "pr-42",
+		Repository: "owner/repo",
+		RunID:      123,
+		RunAttempt: 2,
+		Job:        "deploy",
+		Workflow:   "release",
+		Actor:      "octocat",
+		PR:         42,
+		SHA:        "abc123",
+		AcquiredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		RenewedAt:  time.Date(2026, 1, 2, 3, 9, 5, 0, time.UTC),
+		TTL:        300,
+		Reason:     "terraform apply",
+		Reentries:  2,
+	}
+
+	msg, err := commitMessage("deploy", in)
+	if err != nil {
+		t.Fatalf("commitMessage: %v", err)
+	}
+	out := parseMessage(msg)
+	if out == nil {
+		t.Fatalf("expected holder, got nil from %q", msg)
+	}
+	if *out != *in {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestParseMessage_Foreign(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"fix: something",
+		"action-lock: deploy",
+		"action-lock: deploy\n\nnot json",
+	} {
+		if h := parseMessage(msg); h != nil {
+			t.Errorf("parseMessage(%q) = %+v, want nil", msg, h)
+		}
+	}
+}
+
+func TestNewToken_Unique(t *testing.T) {
+	a, b := NewToken(), NewToken()
+	if len(a) != 32 {
+		t.Errorf("expected 32 hex chars, got %q", a)
+	}
+	if a == b {
+		t.Errorf("expected distinct tokens, got %q twice", a)
+	}
+}
+
+func TestInfo_RenewedAt(t *testing.T) {
+	acquired := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	renewed := acquired.Add(5 * time.Minute)
+	committed := acquired.Add(-time.Hour)
+
+	cases := []struct {
+		name string
+		info Info
+		want time.Time
+	}{
+		{"renewed", Info{Holder: &Holder{AcquiredAt: acquired, RenewedAt: renewed}}, renewed},
+		{"never renewed", Info{Holder: &Holder{AcquiredAt: acquired}}, acquired},
+		{"legacy", Info{CommittedAt: committed}, committed},
+	}
+	for _, tc := range cases {
+		if got := tc.info.RenewedAt(); !got.Equal(tc.want) {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestInfo_ExpiresAt(t *testing.T) {
+	renewed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	info := Info{Holder: &Holder{AcquiredAt: renewed, RenewedAt: renewed, TTL: 60}}
+	if got, want := info.ExpiresAt(), renewed.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	for _, info := range []Info{
+		{Holder: &Holder{AcquiredAt: renewed}},
+		{CommittedAt: renewed},
+	} {
+		if got := info.ExpiresAt(); !got.IsZero() {
+			t.Errorf("expected no expiry for %+v, got %s", info, got)
+		}
+	}
+}
+
+func TestInfo_Stale(t *testing.T) {
+	renewed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	withTTL := Info{Holder: &Holder{RenewedAt: renewed, TTL: 60}}
+	noTTL := Info{Holder: &Holder{RenewedAt: renewed}}
+	legacy := Info{CommittedAt: renewed}
+
+	cases := []struct {
+		name    string
+		info    Info
+		idle    time.Duration
+		maxIdle time.Duration
+		want    bool
+	}{
+		{"ttl not expired", withTTL, 30 * time.Second, 0, false},
+		{"ttl expired", withTTL, 90 * time.Second, 0, true},
+		{"ttl expired despite longer threshold", withTTL, 90 * time.Second, time.Hour, true},
+		{"threshold caps ttl", Info{Holder: &Holder{RenewedAt: renewed, TTL: 3600}}, 15 * time.Minute, 10 * time.Minute, true},
+		{"no ttl within threshold", noTTL, 5 * time.Minute, 10 * time.Minute, false},
+		{"no ttl past threshold", noTTL, 15 * time.Minute, 10 * time.Minute, true},
+		{"no ttl, threshold disabled", noTTL, 24 * time.Hour, 0, false},
+		{"legacy past threshold", legacy, 15 * time.Minute, 10 * time.Minute, true},
+	}
+	for _, tc := range cases {
+		if got := tc.info.Stale(renewed.Add(tc.idle), tc.maxIdle); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestInfo_OwnedBy(t *testing.T) {
+	info := Info{Holder: &Holder{Owner: "pr-42"}}
+	if !info.OwnedBy("pr-42") {
+		t.Error("expected lock to be owned by pr-42")
+	}
+	if info.OwnedBy("pr-43") {
+		t.Error("expected lock not to be owned by pr-43")
+	}
+
+	for _, info := range []Info{
+		{Holder: &Holder{}},
+		{CommittedAt: time.Now()},
+	} {
+		if info.OwnedBy("") {
+			t.Errorf("expected empty owner to own nothing, got true for %+v", info)
+		}
+		if info.OwnedBy("pr-42") {
+			t.Errorf("expected %+v not to be owned by pr-42", info)
+		}
+	}
+}
+
+func TestHolder_RunURL(t *testing.T) {
+	cases := []struct {
+		h    Holder
+		want string
+	}{
+		{Holder{Repository: "owner/repo", RunID: 123}, "https://github.com/owner/repo/actions/runs/123"},
+		{Holder{Repository: "owner/repo", RunID: 123, RunAttempt: 1}, "https://github.com/owner/repo/actions/runs/123"},
+		{Holder{Repository: "owner/repo", RunID: 123, RunAttempt: 3}, "https://github.com/owner/repo/actions/runs/123/attempts/3"},
+		{Holder{Repository: "owner/repo"}, ""},
+		{Holder{RunID: 123}, ""},
+	}
+	for _, tc := range cases {
+		if got := tc.h.RunURL("https://github.com/"); got != tc.want {
+			t.Errorf("RunURL(%+v) = %q, want %q", tc.h, got, tc.want)
+		}
+	}
+}
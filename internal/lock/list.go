@@ -0,0 +1,35 @@
+package lock
+
+import (
+	"context"
+	"strings"
+)
+
+// Entry is a lock found by List.
+type Entry struct {
+	// Name is the lock name, the ref below refs/locks/.
+	Name string
+	Info *Info
+}
+
+// List returns every lock whose name starts with prefix, along with its
+// holder. An empty prefix lists all locks in the repository.
+func (c *Client) List(ctx context.Context, prefix string) ([]Entry, error) {
+	refs, err := c.listRefs(ctx, c.refPath(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(refs))
+	for _, r := range refs {
+		info, err := c.info(ctx, r.Object.SHA)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Name: strings.TrimPrefix(r.Ref, "refs/"+c.refPath("")),
+			Info: info,
+		})
+	}
+	return entries, nil
+}
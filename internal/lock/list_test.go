@@ -0,0 +1,73 @@
+package lock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	gh.pageSize = 1
+
+	if _, err := c.Acquire(ctx, "deploy", &Holder{RunID: 7, Actor: "octocat"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Acquire(ctx, SlotName("cluster", 1), &Holder{RunID: 8}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	date := time.Now().Add(-time.Hour).Truncate(time.Second)
+	gh.refs["locks/legacy"] = gh.addCommit("fix: something", date)
+	gh.refs["lock-queue/deploy/1-7"] = gh.refs["locks/deploy"]
+
+	entries, err := c.List(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 locks, got %d: %+v", len(entries), entries)
+	}
+
+	byName := map[string]*Info{}
+	for _, e := range entries {
+		byName[e.Name] = e.Info
+	}
+	if info := byName["deploy"]; info == nil || info.Holder == nil || info.Holder.Actor != "octocat" {
+		t.Errorf("unexpected deploy entry: %+v", info)
+	}
+	if info := byName["cluster/slot-1"]; info == nil || info.Holder == nil || info.Holder.RunID != 8 {
+		t.Errorf("unexpected slot entry: %+v", info)
+	}
+	if info := byName["legacy"]; info == nil || info.Holder != nil || !info.AcquiredAt().Equal(date) {
+		t.Errorf("unexpected legacy entry: %+v", info)
+	}
+}
+
+func TestList_Prefix(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	for _, name := range []string{"cluster/slot-0", "cluster/slot-1", "clusters", "deploy"} {
+		if _, err := c.Acquire(ctx, name, &Holder{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	entries, err := c.List(ctx, "cluster/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "cluster/slot-0" || entries[1].Name != "cluster/slot-1" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestList_Empty(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	entries, err := c.List(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no locks, got %+v", entries)
+	}
+}
@@ -2,50 +2,122 @@ package lock
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
+// treeContent is the single file in the tree every lock commit points at.
+const treeContent = "This commit is managed by action-lock.\n"
+
 type Client struct {
-	repo    string
-	token   string
-	http    *http.Client
-	baseURL string
+	repo       string
+	auth       TokenSource
+	http       *http.Client
+	baseURL    string
+	graphqlURL string
+	limits     *rateLimits // budget reported by the last response
+	retry      *retryTransport
+
+	mu   sync.Mutex
+	tree string // cached SHA of the lock commit tree
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL points the client at the REST API of another GitHub instance,
+// e.g. https://ghes.example.com/api/v3 for GitHub Enterprise Server.
+func WithBaseURL(url string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimSuffix(url, "/") }
+}
+
+// WithGraphQLURL sets the GraphQL endpoint of the GitHub instance, e.g.
+// https://ghes.example.com/api/graphql for GitHub Enterprise Server.
+func WithGraphQLURL(url string) Option {
+	return func(c *Client) { c.graphqlURL = url }
 }
 
-func New(repo, token string) *Client {
-	return &Client{
-		repo:    repo,
-		token:   token,
-		http:    &http.Client{Timeout: 10 * time.Second},
-		baseURL: "https://api.github.com",
+func New(repo, token string, opts ...Option) *Client {
+	limits := &rateLimits{}
+	retry := newRetryTransport(limits)
+	c := &Client{
+		repo:       repo,
+		auth:       StaticToken(token),
+		http:       &http.Client{Transport: retry, Timeout: 5 * time.Minute},
+		baseURL:    "https://api.github.com",
+		graphqlURL: "https://api.github.com/graphql",
+		limits:     limits,
+		retry:      retry,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	return c
+}
+
+// GraphQLURL returns the GraphQL endpoint of the GitHub instance.
+func (c *Client) GraphQLURL() string {
+	return c.graphqlURL
+}
+
+// SlotName returns the lock name of slot i of a counting semaphore, which is
+// stored as refs/locks/<name>/slot-<i>.
+func SlotName(lockName string, i int) string {
+	return fmt.Sprintf("%s/slot-%d", lockName, i)
 }
 
 func (c *Client) refPath(lockName string) string {
 	return fmt.Sprintf("locks/%s", lockName)
 }
 
-// Acquire attempts to create a git ref as an atomic lock.
+// Acquire attempts to create a git ref as an atomic lock. The ref points at a
+// fresh commit whose message records the holder metadata.
 // Returns true if the lock was acquired, false if it already exists.
-func (c *Client) Acquire(lockName, sha string) (bool, error) {
-	ref := fmt.Sprintf("refs/%s", c.refPath(lockName))
+func (c *Client) Acquire(ctx context.Context, lockName string, h *Holder) (bool, error) {
+	ref := c.refPath(lockName)
+
+	// Skip creating a commit while the lock is visibly held.
+	_, err := c.getRefSHA(ctx, ref)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	meta := *h
+	meta.AcquiredAt = time.Now().UTC()
+	meta.RenewedAt = meta.AcquiredAt
+	sha, err := c.CreateCommit(ctx, lockName, &meta, "")
+	if err != nil {
+		return false, err
+	}
+	return c.createRef(ctx, ref, sha)
+}
 
-	body, _ := json.Marshal(map[string]string{
-		"ref": ref,
+// createRef creates ref pointing at sha. Returns false if the ref already
+// exists, and an error matching ErrInvalidSHA or ErrInvalidRef if GitHub
+// rejects sha or the ref name, or if ref would be nested under an existing
+// ref or the other way round. The request isn't retried, as a repeat would
+// find the ref created by the first attempt and report the lock as held.
+func (c *Client) createRef(ctx context.Context, ref, sha string) (bool, error) {
+	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/repos/%s/git/refs", c.repo), map[string]string{
+		"ref": "refs/" + ref,
 		"sha": sha,
 	})
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/repos/%s/git/refs", c.baseURL, c.repo), bytes.NewReader(body))
 	if err != nil {
 		return false, err
 	}
-	c.setHeaders(req)
 
-	resp, err := c.http.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return false, err
 	}
@@ -55,26 +127,231 @@ func (c *Client) Acquire(lockName, sha string) (bool, error) {
 		return true, nil
 	}
 
-	// 422 = ref already exists (lock held)
-	if resp.StatusCode == http.StatusUnprocessableEntity {
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		return false, newAPIError(resp)
+	}
+
+	// 422 = ref already exists (lock held), but GitHub answers the same to a
+	// SHA it doesn't know or an invalid ref name, which waiting won't fix.
+	// Contention is confirmed by finding the ref.
+	apiErr := newAPIError(resp)
+	if _, err := c.getRefSHA(ctx, ref); !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	msg := strings.ToLower(apiErr.Message)
+	if strings.Contains(msg, "object does not exist") || strings.Contains(msg, `"sha"`) {
+		apiErr.kind = ErrInvalidSHA
+		return false, fmt.Errorf("commit %s doesn't exist in %s: %w", sha, c.repo, apiErr)
+	}
+	conflict, err := c.conflictingRef(ctx, ref)
+	if err != nil {
+		return false, err
+	}
+	if conflict != "" {
+		return false, fmt.Errorf("can't create refs/%s in %s next to refs/%s, as one would be nested under the other (e.g. a lock and a semaphore of the same name): %w", ref, c.repo, conflict, apiErr)
+	}
+	if strings.Contains(msg, "already exists") {
+		// Released again since.
+		return false, nil
+	}
+	return false, fmt.Errorf("can't create refs/%s in %s: %w", ref, c.repo, apiErr)
+}
+
+// conflictingRef returns an existing ref that keeps ref from being created
+// because one of them would be nested under the other, like refs/locks/a
+// and refs/locks/a/slot-0, or "" if there is none.
+func (c *Client) conflictingRef(ctx context.Context, ref string) (string, error) {
+	parts := strings.Split(ref, "/")
+	// The first component is the namespace, e.g. locks.
+	for i := 2; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], "/")
+		_, err := c.getRefSHA(ctx, parent)
+		if err == nil {
+			return parent, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+
+	refs, err := c.listRefs(ctx, ref+"/")
+	if err != nil || len(refs) == 0 {
+		return "", err
+	}
+	return strings.TrimPrefix(refs[0].Ref, "refs/"), nil
+}
+
+// Steal atomically takes over a lock that still points at expectedSHA, one
+// that is stale or held by the same owner, by moving the ref to newSHA, a
+// commit created with expectedSHA as its parent. The ref update is not
+// forced, so GitHub only applies it as a fast-forward: if another waiter took
+// the lock over or it was released and re-acquired in the meantime, the ref
+// no longer points at an ancestor of newSHA and the update is rejected.
+// Returns false if the lock has moved on.
+func (c *Client) Steal(ctx context.Context, lockName, expectedSHA, newSHA string) (bool, error) {
+	return c.advance(ctx, c.refPath(lockName), expectedSHA, newSHA)
+}
+
+// Renew extends the lease of a lock held under token by advancing the ref to
+// a child commit with an updated renewed_at. Returns ErrNotOwner if the lock
+// has been released or taken over by someone else.
+func (c *Client) Renew(ctx context.Context, lockName, token string) error {
+	return c.renew(ctx, c.refPath(lockName), lockName, token, 0)
+}
+
+// renew advances ref, which holds a commit for lockName, to a renewal commit.
+// A positive ttl replaces the lease the holder declared.
+func (c *Client) renew(ctx context.Context, ref, lockName, token string, ttl int) error {
+	info, err := c.inspect(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if info == nil || info.Holder == nil || info.Holder.Token != token {
+		return ErrNotOwner
+	}
+
+	meta := *info.Holder
+	meta.RenewedAt = time.Now().UTC()
+	if ttl > 0 {
+		meta.TTL = ttl
+	}
+	sha, err := c.CreateCommit(ctx, lockName, &meta, info.SHA)
+	if err != nil {
+		return err
+	}
+
+	renewed, err := c.advance(ctx, ref, info.SHA, sha)
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// advance moves ref from expectedSHA to its descendant newSHA without
+// forcing, and reports whether the ref now points at newSHA.
+func (c *Client) advance(ctx context.Context, ref, expectedSHA, newSHA string) (bool, error) {
+	current, err := c.getRefSHA(ctx, ref)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil || current != expectedSHA {
+		return false, err
+	}
+
+	// The update may be retried: a repeat after an attempt that took effect
+	// is rejected, and the check below still finds the ref at newSHA.
+	req, err := c.newRequest(idempotent(ctx), "PATCH", fmt.Sprintf("/repos/%s/git/refs/%s", c.repo, escapeRef(ref)), map[string]any{
+		"sha":   newSHA,
+		"force": false,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// 422 = not a fast-forward or the ref is gone (lock moved on), unless
+	// the update was retried after it had already been applied.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return false, newAPIError(resp)
+	}
+
+	// Verify the ref now points at our commit.
+	current, err = c.getRefSHA(ctx, ref)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return current == newSHA, nil
+}
+
+// Release deletes the lock ref if it is still held under the given owner
+// token. Returns false if the lock doesn't exist, and ErrNotOwner if it was
+// acquired by someone else, e.g. after this run's lock was taken over as stale.
+//
+// GitHub has no conditional delete, so a takeover between the ownership check
+// and the delete can still be lost; the window is a single API round trip.
+func (c *Client) Release(ctx context.Context, lockName, token string) (bool, error) {
+	info, err := c.Inspect(ctx, lockName)
+	if err != nil {
+		return false, err
+	}
+	if info == nil {
 		return false, nil
 	}
+	if info.Holder == nil || info.Holder.Token != token {
+		return false, ErrNotOwner
+	}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	return false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+	if err := c.deleteRef(ctx, c.refPath(lockName), info.SHA); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// Release deletes the lock ref.
-func (c *Client) Release(lockName string) error {
+// ForceRelease deletes the lock ref regardless of who holds it.
+func (c *Client) ForceRelease(ctx context.Context, lockName string) error {
 	ref := c.refPath(lockName)
+	sha, err := c.getRefSHA(ctx, ref)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.deleteRef(ctx, ref, sha)
+}
 
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/repos/%s/git/refs/%s", c.baseURL, c.repo, ref), nil)
+// deleteRef deletes ref, which pointed at sha when it was inspected. Deleting
+// a ref that doesn't exist is a no-op.
+//
+// The transport doesn't retry deletions: one that took effect before failing
+// would, once repeated, delete the lock of whoever acquired the name since.
+// Transient failures are retried here instead, each time only if the ref
+// still points at sha. An empty sha skips the check, for refs whose names
+// are never reused.
+func (c *Client) deleteRef(ctx context.Context, ref, sha string) error {
+	for attempt := 1; ; attempt++ {
+		err := c.deleteRefOnce(ctx, ref)
+		if !errors.Is(err, ErrTransient) || attempt == c.retry.attempts {
+			return err
+		}
+		if err := sleep(ctx, backoff(attempt, c.retry.base, c.retry.limit)); err != nil {
+			return err
+		}
+		if sha == "" {
+			continue
+		}
+		current, err := c.getRefSHA(ctx, ref)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if current != sha {
+			// Deleted by the failed attempt and acquired again since.
+			return nil
+		}
+	}
+}
+
+func (c *Client) deleteRefOnce(ctx context.Context, ref string) error {
+	req, err := c.newRequest(ctx, "DELETE", fmt.Sprintf("/repos/%s/git/refs/%s", c.repo, escapeRef(ref)), nil)
 	if err != nil {
 		return err
 	}
-	c.setHeaders(req)
 
-	resp, err := c.http.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -84,45 +361,74 @@ func (c *Client) Release(lockName string) error {
 		return nil
 	}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+	// GitHub answers 422 to deleting a ref that doesn't exist, e.g. one
+	// already deleted by a previous attempt or another run.
+	apiErr := newAPIError(resp)
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		if _, err := c.getRefSHA(ctx, ref); errors.Is(err, ErrNotFound) {
+			return nil
+		}
+	}
+	return apiErr
 }
 
-// LockAge returns the age of the lock in seconds, or -1 if the lock doesn't exist.
-func (c *Client) LockAge(lockName string) (int, error) {
-	ref := c.refPath(lockName)
+// Inspect returns the commit the lock ref points at, or nil if the lock
+// doesn't exist.
+func (c *Client) Inspect(ctx context.Context, lockName string) (*Info, error) {
+	return c.inspect(ctx, c.refPath(lockName))
+}
 
-	// Get the ref to find its SHA
-	sha, err := c.getRefSHA(ref)
+func (c *Client) inspect(ctx context.Context, ref string) (*Info, error) {
+	sha, err := c.getRefSHA(ctx, ref)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		return -1, nil // ref doesn't exist
+		return nil, err
 	}
+	return c.info(ctx, sha)
+}
 
-	// Get the commit date
-	commitDate, err := c.getCommitDate(sha)
+// info describes the lock commit sha.
+func (c *Client) info(ctx context.Context, sha string) (*Info, error) {
+	commit, err := c.getCommit(ctx, sha)
 	if err != nil {
+		return nil, err
+	}
+
+	return &Info{
+		SHA:         sha,
+		Holder:      parseMessage(commit.Message),
+		CommittedAt: commit.Committer.Date,
+	}, nil
+}
+
+// LockAge returns the time in seconds since the lock was acquired, or -1 if
+// the lock doesn't exist.
+func (c *Client) LockAge(ctx context.Context, lockName string) (int, error) {
+	info, err := c.Inspect(ctx, lockName)
+	if err != nil || info == nil {
 		return -1, err
 	}
 
-	age := int(time.Since(commitDate).Seconds())
+	age := int(time.Since(info.AcquiredAt()).Seconds())
 	return age, nil
 }
 
-func (c *Client) getRefSHA(ref string) (string, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/git/ref/%s", c.baseURL, c.repo, ref), nil)
+func (c *Client) getRefSHA(ctx context.Context, ref string) (string, error) {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/repos/%s/git/ref/%s", c.repo, escapeRef(ref)), nil)
 	if err != nil {
 		return "", err
 	}
-	c.setHeaders(req)
 
-	resp, err := c.http.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("ref not found: %d", resp.StatusCode)
+		return "", newAPIError(resp)
 	}
 
 	var result struct {
@@ -136,36 +442,208 @@ func (c *Client) getRefSHA(ref string) (string, error) {
 	return result.Object.SHA, nil
 }
 
-func (c *Client) getCommitDate(sha string) (time.Time, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/repos/%s/git/commits/%s", c.baseURL, c.repo, sha), nil)
+type commit struct {
+	Message   string `json:"message"`
+	Committer struct {
+		Date time.Time `json:"date"`
+	} `json:"committer"`
+}
+
+func (c *Client) getCommit(ctx context.Context, sha string) (*commit, error) {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/repos/%s/git/commits/%s", c.repo, sha), nil)
 	if err != nil {
-		return time.Time{}, err
+		return nil, err
 	}
-	c.setHeaders(req)
 
-	resp, err := c.http.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return time.Time{}, err
+		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return time.Time{}, fmt.Errorf("commit not found: %d", resp.StatusCode)
+		return nil, newAPIError(resp)
+	}
+
+	var result commit
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateCommit writes a lock commit carrying the holder metadata and returns
+// its SHA. The commit is parentless unless parent is given, as it is when
+// taking over an existing lock with Steal.
+func (c *Client) CreateCommit(ctx context.Context, lockName string, h *Holder, parent string) (string, error) {
+	tree, err := c.lockTree(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := commitMessage(lockName, h)
+	if err != nil {
+		return "", err
+	}
+
+	parents := []string{}
+	if parent != "" {
+		parents = append(parents, parent)
+	}
+
+	return c.createObject(ctx, fmt.Sprintf("/repos/%s/git/commits", c.repo), map[string]any{
+		"message": msg,
+		"tree":    tree,
+		"parents": parents,
+	})
+}
+
+// lockTree returns the tree shared by all lock commits, creating it on first use.
+func (c *Client) lockTree(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tree != "" {
+		return c.tree, nil
+	}
+
+	sha, err := c.createObject(ctx, fmt.Sprintf("/repos/%s/git/trees", c.repo), map[string]any{
+		"tree": []map[string]string{{
+			"path":    ".action-lock",
+			"mode":    "100644",
+			"type":    "blob",
+			"content": treeContent,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	c.tree = sha
+	return sha, nil
+}
+
+// createObject POSTs a git object and returns the SHA of the created object.
+func (c *Client) createObject(ctx context.Context, path string, payload any) (string, error) {
+	req, err := c.newRequest(idempotent(ctx), "POST", path, payload)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", newAPIError(resp)
 	}
 
 	var result struct {
-		Committer struct {
-			Date time.Time `json:"date"`
-		} `json:"committer"`
+		SHA string `json:"sha"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return time.Time{}, err
+		return "", err
+	}
+	return result.SHA, nil
+}
+
+type refEntry struct {
+	Ref    string `json:"ref"`
+	Object struct {
+		SHA string `json:"sha"`
+	} `json:"object"`
+}
+
+// listRefs returns all refs starting with refs/<prefix>, following pagination.
+func (c *Client) listRefs(ctx context.Context, prefix string) ([]refEntry, error) {
+	var refs []refEntry
+	url := fmt.Sprintf("%s/repos/%s/git/matching-refs/%s?per_page=100", c.baseURL, c.repo, escapeRef(prefix))
+	for url != "" {
+		req, err := c.newRequestURL(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := newAPIError(resp)
+			_ = resp.Body.Close()
+			return nil, err
+		}
+
+		var page []refEntry
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// matching-refs matches on any prefix, e.g. refs/locks/a also
+		// matches refs/locks/ab, so filter to the exact prefix.
+		for _, r := range page {
+			if strings.HasPrefix(r.Ref, "refs/"+prefix) {
+				refs = append(refs, r)
+			}
+		}
+		url = nextLink(resp.Header.Get("Link"))
+	}
+	return refs, nil
+}
+
+// escapeRef escapes ref for use in a URL path. Characters such as "#" and
+// "%" are valid in ref names but not in URLs.
+func escapeRef(ref string) string {
+	parts := strings.Split(ref, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// nextLink returns the rel="next" URL of a Link header, or "".
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		url, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if ok && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(url), "<>")
+		}
+	}
+	return ""
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, payload any) (*http.Request, error) {
+	return c.newRequestURL(ctx, method, c.baseURL+path, payload)
+}
+
+func (c *Client) newRequestURL(ctx context.Context, method, url string, payload any) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.auth.Token(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return result.Committer.Date, nil
+	c.setHeaders(req, token)
+	return req, nil
 }
 
-func (c *Client) setHeaders(req *http.Request) {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+func (c *Client) setHeaders(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 	req.Header.Set("Content-Type", "application/json")
@@ -1,68 +1,380 @@
 package lock
 
 import (
+	"context"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// ctx is the context of client calls in tests.
+var ctx = context.Background()
+
 func newTestClient(url string) *Client {
-	c := New("owner/repo", "test-token")
-	c.baseURL = url
+	c := New("owner/repo", "test-token", WithBaseURL(url))
+	rt := c.http.Transport.(*retryTransport)
+	rt.base, rt.limit = time.Millisecond, 4*time.Millisecond
 	return c
 }
 
-// --------------- Acquire ---------------
+// fakeGitHub is an in-memory stand-in for the git database API.
+type fakeGitHub struct {
+	t       *testing.T
+	mu      sync.Mutex
+	refs    map[string]string    // ref without "refs/" prefix -> commit SHA
+	commits map[string]string    // commit SHA -> message
+	dates   map[string]time.Time // commit SHA -> committer date
+	parents map[string][]string  // commit SHA -> parent SHAs
+	trees   int
+	reads   int // commits read
 
-func TestAcquire_Success(t *testing.T) {
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			t.Errorf("expected POST, got %s", r.Method)
+	// pageSize limits matching-refs responses to exercise pagination.
+	pageSize int
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *Client) {
+	t.Helper()
+	gh := &fakeGitHub{
+		t:        t,
+		refs:     map[string]string{},
+		commits:  map[string]string{},
+		dates:    map[string]time.Time{},
+		parents:  map[string][]string{},
+		pageSize: 100,
+	}
+	srv := httptest.NewServer(gh)
+	t.Cleanup(srv.Close)
+	return gh, newTestClient(srv.URL)
+}
+
+// addCommit stores a commit with the given message and date and returns its SHA.
+func (gh *fakeGitHub) addCommit(msg string, date time.Time, parents ...string) string {
+	sha := fmt.Sprintf("commit%d", len(gh.commits)+1)
+	gh.commits[sha] = msg
+	gh.dates[sha] = date
+	gh.parents[sha] = parents
+	return sha
+}
+
+// isAncestor reports whether ancestor is reachable from sha.
+func (gh *fakeGitHub) isAncestor(ancestor, sha string) bool {
+	if ancestor == sha {
+		return true
+	}
+	for _, p := range gh.parents[sha] {
+		if gh.isAncestor(ancestor, p) {
+			return true
 		}
-		if r.URL.Path != "/repos/owner/repo/git/refs" {
-			t.Errorf("unexpected path: %s", r.URL.Path)
+	}
+	return false
+}
+
+func (gh *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+
+	const prefix = "/repos/owner/repo/git/"
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	var payload struct {
+		Ref     string   `json:"ref"`
+		SHA     string   `json:"sha"`
+		Force   bool     `json:"force"`
+		Message string   `json:"message"`
+		Parents []string `json:"parents"`
+	}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+	}
+
+	switch {
+	case r.Method == "POST" && path == "trees":
+		gh.trees++
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sha": fmt.Sprintf("tree%d", gh.trees)})
+
+	case r.Method == "POST" && path == "commits":
+		sha := gh.addCommit(payload.Message, time.Now(), payload.Parents...)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"sha": sha})
+
+	case r.Method == "GET" && strings.HasPrefix(path, "commits/"):
+		sha := strings.TrimPrefix(path, "commits/")
+		gh.reads++
+		msg, ok := gh.commits[sha]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
-			t.Errorf("unexpected auth header: %s", got)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"sha":       sha,
+			"message":   msg,
+			"committer": map[string]string{"date": gh.dates[sha].Format(time.RFC3339)},
+		})
+
+	case r.Method == "POST" && path == "refs":
+		ref := strings.TrimPrefix(payload.Ref, "refs/")
+		if _, ok := gh.commits[payload.SHA]; !ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Object does not exist"}`))
+			return
+		}
+		for existing := range gh.refs {
+			// Git can't nest refs under one another.
+			if existing == ref || strings.HasPrefix(existing, ref+"/") || strings.HasPrefix(ref, existing+"/") {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"message":"Reference already exists"}`))
+				return
+			}
 		}
+		gh.refs[ref] = payload.SHA
+		w.WriteHeader(http.StatusCreated)
 
-		body, _ := io.ReadAll(r.Body)
-		var payload map[string]string
-		if err := json.Unmarshal(body, &payload); err != nil {
-			t.Fatalf("unmarshal body: %v", err)
+	case r.Method == "GET" && strings.HasPrefix(path, "ref/"):
+		sha, ok := gh.refs[strings.TrimPrefix(path, "ref/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
-		if payload["ref"] != "refs/locks/deploy" {
-			t.Errorf("unexpected ref: %s", payload["ref"])
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ref":    "refs/" + strings.TrimPrefix(path, "ref/"),
+			"object": map[string]string{"sha": sha},
+		})
+
+	case r.Method == "GET" && strings.HasPrefix(path, "matching-refs/"):
+		prefix := strings.TrimPrefix(path, "matching-refs/")
+		var names []string
+		for ref := range gh.refs {
+			if strings.HasPrefix(ref, prefix) {
+				names = append(names, ref)
+			}
 		}
-		if payload["sha"] != "abc123" {
-			t.Errorf("unexpected sha: %s", payload["sha"])
+		sort.Strings(names)
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		page = max(page, 1)
+		start := min((page-1)*gh.pageSize, len(names))
+		end := min(start+gh.pageSize, len(names))
+		if end < len(names) {
+			// Link to the URL as requested, before any prefix was stripped.
+			next, _ := url.Parse(r.RequestURI)
+			q := next.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
 		}
 
-		w.WriteHeader(http.StatusCreated)
-	}))
+		entries := []map[string]any{}
+		for _, ref := range names[start:end] {
+			entries = append(entries, map[string]any{
+				"ref":    "refs/" + ref,
+				"object": map[string]string{"sha": gh.refs[ref]},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(entries)
+
+	case r.Method == "PATCH" && strings.HasPrefix(path, "refs/"):
+		ref := strings.TrimPrefix(path, "refs/")
+		current, ok := gh.refs[ref]
+		if !ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Reference does not exist"}`))
+			return
+		}
+		if !payload.Force && !gh.isAncestor(current, payload.SHA) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Update is not a fast forward"}`))
+			return
+		}
+		gh.refs[ref] = payload.SHA
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ref":    "refs/" + ref,
+			"object": map[string]string{"sha": payload.SHA},
+		})
+
+	case r.Method == "DELETE" && strings.HasPrefix(path, "refs/"):
+		ref := strings.TrimPrefix(path, "refs/")
+		if _, ok := gh.refs[ref]; !ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Reference does not exist"}`))
+			return
+		}
+		delete(gh.refs, ref)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		gh.t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// --------------- New ---------------
+
+func TestNew_EnterpriseServer(t *testing.T) {
+	gh, _ := newFakeGitHub(t)
+	gh.pageSize = 1
+
+	// GitHub Enterprise Server serves the REST API under /api/v3.
+	mux := http.NewServeMux()
+	mux.Handle("/api/v3/", http.StripPrefix("/api/v3", gh))
+	srv := httptest.NewServer(mux)
 	defer srv.Close()
 
-	c := newTestClient(srv.URL)
-	acquired, err := c.Acquire("deploy", "abc123")
+	c := New("owner/repo", "test-token", WithBaseURL(srv.URL+"/api/v3/"), WithGraphQLURL(srv.URL+"/api/graphql"))
+	for _, name := range []string{"a", "b"} {
+		acquired, err := c.Acquire(ctx, name, &Holder{Token: "mine"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !acquired {
+			t.Fatalf("expected lock %q to be acquired", name)
+		}
+	}
+
+	entries, err := c.List(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 locks across pages, got %d", len(entries))
+	}
+	if released, err := c.Release(ctx, "a", "mine"); err != nil || !released {
+		t.Errorf("expected lock to be released, got %t, %v", released, err)
+	}
+	if got := c.GraphQLURL(); got != srv.URL+"/api/graphql" {
+		t.Errorf("unexpected GraphQL URL %q", got)
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	c := New("owner/repo", "test-token")
+	if c.baseURL != "https://api.github.com" {
+		t.Errorf("unexpected base URL %q", c.baseURL)
+	}
+	if c.GraphQLURL() != "https://api.github.com/graphql" {
+		t.Errorf("unexpected GraphQL URL %q", c.GraphQLURL())
+	}
+}
+
+// --------------- Acquire ---------------
+
+func TestAcquire_Success(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	acquired, err := c.Acquire(ctx, "deploy", &Holder{RunID: 42, Actor: "octocat"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if !acquired {
 		t.Error("expected acquired to be true")
 	}
+
+	sha, ok := gh.refs["locks/deploy"]
+	if !ok {
+		t.Fatal("expected refs/locks/deploy to be created")
+	}
+	h := parseMessage(gh.commits[sha])
+	if h == nil {
+		t.Fatalf("expected holder metadata in commit message, got %q", gh.commits[sha])
+	}
+	if h.RunID != 42 || h.Actor != "octocat" {
+		t.Errorf("unexpected holder: %+v", h)
+	}
+	if time.Since(h.AcquiredAt) > time.Minute {
+		t.Errorf("expected acquired_at to be now, got %s", h.AcquiredAt)
+	}
+}
+
+func TestAcquire_ReusesTree(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	if _, err := c.Acquire(ctx, "a", &Holder{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Acquire(ctx, "b", &Holder{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gh.trees != 1 {
+		t.Errorf("expected 1 tree to be created, got %d", gh.trees)
+	}
 }
 
 func TestAcquire_AlreadyHeld(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	gh.refs["locks/deploy"] = "other"
+
+	acquired, err := c.Acquire(ctx, "deploy", &Holder{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if acquired {
+		t.Error("expected acquired to be false")
+	}
+	if gh.refs["locks/deploy"] != "other" {
+		t.Error("expected existing lock to be untouched")
+	}
+}
+
+func TestAcquire_Race(t *testing.T) {
+	var gets atomic.Int32
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+		switch {
+		case r.Method == "GET" && gets.Add(1) == 1:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "GET":
+			// Another waiter took the lock after our check.
+			_, _ = w.Write([]byte(`{"object":{"sha":"other"}}`))
+		case r.URL.Path == "/repos/owner/repo/git/refs":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Reference already exists"}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]string{"sha": "abc123"})
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	acquired, err := c.Acquire(ctx, "deploy", &Holder{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if acquired {
+		t.Error("expected acquired to be false")
+	}
+}
+
+func TestAcquire_ReleasedAfterConflict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/matching-refs/"):
+			_, _ = w.Write([]byte("[]"))
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/repos/owner/repo/git/refs":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"Reference already exists"}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]string{"sha": "abc123"})
+		}
 	}))
 	defer srv.Close()
 
+	// The lock was taken and released again between the creation and the
+	// check; the next poll may get it.
 	c := newTestClient(srv.URL)
-	acquired, err := c.Acquire("deploy", "abc123")
+	acquired, err := c.Acquire(ctx, "deploy", &Holder{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -71,6 +383,94 @@ func TestAcquire_AlreadyHeld(t *testing.T) {
 	}
 }
 
+func TestAcquire_InvalidRefName(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/matching-refs/"):
+			_, _ = w.Write([]byte("[]"))
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/repos/owner/repo/git/refs":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"message":"refs/locks/deploy..prod is not a valid ref name."}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]string{"sha": "abc123"})
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	acquired, err := c.Acquire(ctx, "deploy..prod", &Holder{})
+	if !errors.Is(err, ErrInvalidRef) {
+		t.Fatalf("expected ErrInvalidRef, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "not a valid ref name") {
+		t.Errorf("expected GitHub's message in the error, got %v", err)
+	}
+	if acquired {
+		t.Error("expected acquired to be false")
+	}
+}
+
+func TestAcquire_Nested(t *testing.T) {
+	for _, tt := range []struct {
+		name, held, lock, conflict string
+	}{
+		{"under a lock", "deploy", "deploy/prod", "refs/locks/deploy"},
+		{"over a semaphore", "deploy/slot-0", "deploy", "refs/locks/deploy/slot-0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gh, c := newFakeGitHub(t)
+			gh.refs["locks/"+tt.held] = gh.addCommit("held", time.Now())
+
+			acquired, err := c.Acquire(ctx, tt.lock, &Holder{})
+			if !errors.Is(err, ErrInvalidRef) {
+				t.Fatalf("expected ErrInvalidRef, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.conflict) {
+				t.Errorf("expected %s to be named in the error, got %v", tt.conflict, err)
+			}
+			if acquired {
+				t.Error("expected acquired to be false")
+			}
+		})
+	}
+}
+
+func TestAcquire_URLSpecialCharacters(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	acquired, err := c.Acquire(ctx, "50%#off", &Holder{Token: "mine"})
+	if err != nil || !acquired {
+		t.Fatalf("expected lock to be acquired, got %t, %v", acquired, err)
+	}
+	if _, ok := gh.refs["locks/50%#off"]; !ok {
+		t.Errorf("expected refs/locks/50%%#off, got %v", gh.refs)
+	}
+	if released, err := c.Release(ctx, "50%#off", "mine"); err != nil || !released {
+		t.Errorf("expected lock to be released, got %t, %v", released, err)
+	}
+}
+
+func TestCreateRef_UnknownSHA(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	created, err := c.createRef(ctx, "locks/deploy", "missing")
+	if !errors.Is(err, ErrInvalidSHA) {
+		t.Fatalf("expected ErrInvalidSHA, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "commit missing doesn't exist in owner/repo") {
+		t.Errorf("expected the commit to be named in the error, got %v", err)
+	}
+	if created {
+		t.Error("expected created to be false")
+	}
+	if _, ok := gh.refs["locks/deploy"]; ok {
+		t.Error("expected no lock ref")
+	}
+}
+
 func TestAcquire_ServerError(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -79,7 +479,7 @@ func TestAcquire_ServerError(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	acquired, err := c.Acquire("deploy", "abc123")
+	acquired, err := c.Acquire(ctx, "deploy", &Holder{})
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -88,10 +488,72 @@ func TestAcquire_ServerError(t *testing.T) {
 	}
 }
 
-// --------------- Release ---------------
+func TestAcquire_OtherRepository(t *testing.T) {
+	gh, _ := newFakeGitHub(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/repos/org/locks/")
+		if !ok {
+			t.Errorf("unexpected request outside the lock repository: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.URL.Path = "/repos/owner/repo/" + path
+		gh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	// The caller's commit doesn't exist in the lock repository, so the lock
+	// commit must not refer to it.
+	c := newTestClient(srv.URL)
+	c.repo = "org/locks"
+	acquired, err := c.Acquire(ctx, "cluster", &Holder{Token: "mine", Repository: "org/service", SHA: "caller-sha"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !acquired {
+		t.Fatal("expected lock to be acquired")
+	}
 
-func TestRelease_Success(t *testing.T) {
+	info, err := c.Inspect(ctx, "cluster")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gh.parents[info.SHA]) != 0 {
+		t.Errorf("expected a parentless lock commit, got parents %v", gh.parents[info.SHA])
+	}
+	if info.Holder == nil || info.Holder.Repository != "org/service" || info.Holder.SHA != "caller-sha" {
+		t.Errorf("expected the caller to be recorded as the holder, got %+v", info.Holder)
+	}
+	if released, err := c.Release(ctx, "cluster", "mine"); err != nil || !released {
+		t.Errorf("expected lock to be released, got %t, %v", released, err)
+	}
+}
+
+func TestAcquire_Cancelled(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	acquired, err := c.Acquire(cancelled, "deploy", &Holder{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if acquired {
+		t.Error("expected acquired to be false")
+	}
+	if _, ok := gh.refs["locks/deploy"]; ok {
+		t.Error("expected no lock ref")
+	}
+}
+
+// --------------- ForceRelease ---------------
+
+func TestForceRelease_Success(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{"object":{"sha":"abc123"}}`))
+			return
+		}
 		if r.Method != "DELETE" {
 			t.Errorf("expected DELETE, got %s", r.Method)
 		}
@@ -103,24 +565,50 @@ func TestRelease_Success(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	if err := c.Release("deploy"); err != nil {
+	if err := c.ForceRelease(ctx, "deploy"); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-func TestRelease_NotFound_Idempotent(t *testing.T) {
+func TestForceRelease_NotFound_Idempotent(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 	}))
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	if err := c.Release("deploy"); err != nil {
+	if err := c.ForceRelease(ctx, "deploy"); err != nil {
 		t.Fatalf("expected nil error for 404, got: %v", err)
 	}
 }
 
-func TestRelease_ServerError(t *testing.T) {
+func TestForceRelease_AlreadyDeleted(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	// GitHub answers 422 rather than 404 to deleting a missing ref.
+	if err := c.ForceRelease(ctx, "deploy"); err != nil {
+		t.Fatalf("expected nil error for a missing ref, got: %v", err)
+	}
+}
+
+func TestForceRelease_Rejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			_, _ = w.Write([]byte(`{"object":{"sha":"abc123"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"message":"Cannot delete a protected ref"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	if err := c.ForceRelease(ctx, "deploy"); !errors.Is(err, ErrInvalidRef) {
+		t.Fatalf("expected ErrInvalidRef, got %v", err)
+	}
+}
+
+func TestForceRelease_ServerError(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte("server error"))
@@ -128,11 +616,221 @@ func TestRelease_ServerError(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	if err := c.Release("deploy"); err == nil {
+	if err := c.ForceRelease(ctx, "deploy"); err == nil {
 		t.Fatal("expected error")
 	}
 }
 
+func TestAcquire_Slot(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	gh.refs["locks/cluster/slot-0"] = "other"
+
+	acquired, err := c.Acquire(ctx, SlotName("cluster", 1), &Holder{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !acquired {
+		t.Error("expected acquired to be true")
+	}
+	if _, ok := gh.refs["locks/cluster/slot-1"]; !ok {
+		t.Error("expected refs/locks/cluster/slot-1 to be created")
+	}
+}
+
+// --------------- Steal ---------------
+
+func TestSteal_Success(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	stale := gh.addCommit("fix: something", time.Now().Add(-time.Hour))
+	gh.refs["locks/deploy"] = stale
+
+	newSHA, err := c.CreateCommit(ctx, "deploy", &Holder{Token: "mine"}, stale)
+	if err != nil {
+		t.Fatalf("CreateCommit: %v", err)
+	}
+	stolen, err := c.Steal(ctx, "deploy", stale, newSHA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !stolen {
+		t.Error("expected stolen to be true")
+	}
+	if gh.refs["locks/deploy"] != newSHA {
+		t.Errorf("expected ref to point at %s, got %s", newSHA, gh.refs["locks/deploy"])
+	}
+}
+
+func TestSteal_LostRace(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	stale := gh.addCommit("fix: something", time.Now().Add(-time.Hour))
+	gh.refs["locks/deploy"] = stale
+
+	// Two waiters observe the same stale lock and prepare takeover commits.
+	first, _ := c.CreateCommit(ctx, "deploy", &Holder{Token: "first"}, stale)
+	second, _ := c.CreateCommit(ctx, "deploy", &Holder{Token: "second"}, stale)
+
+	// The first waiter's update lands between our check and our update.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PATCH" {
+			gh.mu.Lock()
+			gh.refs["locks/deploy"] = first
+			gh.mu.Unlock()
+		}
+		gh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c.baseURL = srv.URL
+
+	stolen, err := c.Steal(ctx, "deploy", stale, second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stolen {
+		t.Error("expected steal to fail")
+	}
+	if gh.refs["locks/deploy"] != first {
+		t.Errorf("expected first waiter to keep the lock, got %s", gh.refs["locks/deploy"])
+	}
+}
+
+func TestSteal_LockMovedOn(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	stale := gh.addCommit("fix: something", time.Now().Add(-time.Hour))
+	fresh := gh.addCommit("fix: other", time.Now())
+	gh.refs["locks/deploy"] = fresh
+
+	newSHA, _ := c.CreateCommit(ctx, "deploy", &Holder{}, stale)
+	stolen, err := c.Steal(ctx, "deploy", stale, newSHA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stolen {
+		t.Error("expected steal to fail")
+	}
+	if gh.refs["locks/deploy"] != fresh {
+		t.Errorf("expected fresh lock to be kept, got %s", gh.refs["locks/deploy"])
+	}
+}
+
+// --------------- Renew ---------------
+
+func TestRenew_Success(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine", RunID: 7}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	before, _ := c.Inspect(ctx, "deploy")
+
+	if err := c.Renew(ctx, "deploy", "mine"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after, err := c.Inspect(ctx, "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after.SHA == before.SHA {
+		t.Fatal("expected ref to advance")
+	}
+	if got := gh.parents[after.SHA]; len(got) != 1 || got[0] != before.SHA {
+		t.Errorf("expected renewal commit on top of %s, got parents %v", before.SHA, got)
+	}
+	if !after.Holder.AcquiredAt.Equal(before.Holder.AcquiredAt) {
+		t.Errorf("expected acquired_at to be kept, got %s", after.Holder.AcquiredAt)
+	}
+	if after.Holder.RenewedAt.Before(before.Holder.RenewedAt) {
+		t.Errorf("expected renewed_at to move forward, got %s", after.Holder.RenewedAt)
+	}
+	if after.Holder.Token != "mine" || after.Holder.RunID != 7 {
+		t.Errorf("expected holder to be kept, got %+v", after.Holder)
+	}
+}
+
+func TestRenew_NotOwner(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "theirs"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sha := gh.refs["locks/deploy"]
+
+	if err := c.Renew(ctx, "deploy", "mine"); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected ErrNotOwner, got %v", err)
+	}
+	if gh.refs["locks/deploy"] != sha {
+		t.Error("expected lock ref to be untouched")
+	}
+}
+
+func TestRenew_Released(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	if err := c.Renew(ctx, "deploy", "mine"); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected ErrNotOwner, got %v", err)
+	}
+}
+
+// --------------- Release ---------------
+
+func TestRelease_Owner(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	released, err := c.Release(ctx, "deploy", "mine")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !released {
+		t.Error("expected released to be true")
+	}
+	if _, ok := gh.refs["locks/deploy"]; ok {
+		t.Error("expected lock ref to be deleted")
+	}
+}
+
+func TestRelease_NotOwner(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "theirs"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	released, err := c.Release(ctx, "deploy", "mine")
+	if !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected ErrNotOwner, got %v", err)
+	}
+	if released {
+		t.Error("expected released to be false")
+	}
+	if _, ok := gh.refs["locks/deploy"]; !ok {
+		t.Error("expected lock ref to be kept")
+	}
+}
+
+func TestRelease_LegacyLock(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	gh.refs["locks/deploy"] = gh.addCommit("fix: something", time.Now())
+
+	if _, err := c.Release(ctx, "deploy", "mine"); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("expected ErrNotOwner, got %v", err)
+	}
+	if _, ok := gh.refs["locks/deploy"]; !ok {
+		t.Error("expected lock ref to be kept")
+	}
+}
+
+func TestRelease_NotHeld(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	released, err := c.Release(ctx, "deploy", "mine")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if released {
+		t.Error("expected released to be false")
+	}
+}
+
 // --------------- LockAge ---------------
 
 func TestLockAge_Found(t *testing.T) {
@@ -156,7 +854,7 @@ func TestLockAge_Found(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	age, err := c.LockAge("deploy")
+	age, err := c.LockAge(ctx, "deploy")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -172,7 +870,7 @@ func TestLockAge_RefNotFound(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	age, err := c.LockAge("deploy")
+	age, err := c.LockAge(ctx, "deploy")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -181,6 +879,24 @@ func TestLockAge_RefNotFound(t *testing.T) {
 	}
 }
 
+func TestLockAge_RefError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+	}))
+	defer srv.Close()
+
+	// A failed lookup isn't mistaken for a lock that doesn't exist.
+	c := newTestClient(srv.URL)
+	age, err := c.LockAge(ctx, "deploy")
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if age != -1 {
+		t.Errorf("expected -1, got %d", age)
+	}
+}
+
 func TestLockAge_CommitError(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
@@ -197,7 +913,7 @@ func TestLockAge_CommitError(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(srv.URL)
-	age, err := c.LockAge("deploy")
+	age, err := c.LockAge(ctx, "deploy")
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -205,3 +921,148 @@ func TestLockAge_CommitError(t *testing.T) {
 		t.Errorf("expected -1, got %d", age)
 	}
 }
+
+func TestLockAge_UsesHolderAcquiredAt(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	// The commit is old, but the lock was only just taken on it.
+	msg, err := commitMessage("deploy", &Holder{AcquiredAt: time.Now().Add(-30 * time.Second)})
+	if err != nil {
+		t.Fatalf("commitMessage: %v", err)
+	}
+	gh.refs["locks/deploy"] = gh.addCommit(msg, time.Now().Add(-7*24*time.Hour))
+
+	age, err := c.LockAge(ctx, "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if age < 29 || age > 35 {
+		t.Errorf("expected age ~30s, got %d", age)
+	}
+}
+
+// --------------- Inspect ---------------
+
+func TestInspect_Holder(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	if _, err := c.Acquire(ctx, "deploy", &Holder{RunID: 7, Workflow: "deploy", Reason: "release"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := c.Inspect(ctx, "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info == nil || info.Holder == nil {
+		t.Fatalf("expected holder metadata, got %+v", info)
+	}
+	if info.SHA != gh.refs["locks/deploy"] {
+		t.Errorf("expected sha %s, got %s", gh.refs["locks/deploy"], info.SHA)
+	}
+	if info.Holder.RunID != 7 || info.Holder.Reason != "release" {
+		t.Errorf("unexpected holder: %+v", info.Holder)
+	}
+}
+
+func TestInspect_LegacyCommit(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	date := time.Now().Add(-time.Hour).Truncate(time.Second)
+	gh.refs["locks/deploy"] = gh.addCommit("fix: something", date)
+
+	info, err := c.Inspect(ctx, "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Holder != nil {
+		t.Errorf("expected no holder for legacy lock, got %+v", info.Holder)
+	}
+	if !info.AcquiredAt().Equal(date) {
+		t.Errorf("expected acquired at commit date %s, got %s", date, info.AcquiredAt())
+	}
+}
+
+func TestInspect_NotFound(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	info, err := c.Inspect(ctx, "deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info != nil {
+		t.Errorf("expected nil info, got %+v", info)
+	}
+}
+
+func TestInspect_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	info, err := c.Inspect(ctx, "deploy")
+	if !errors.Is(err, ErrTransient) {
+		t.Fatalf("expected ErrTransient, got %v", err)
+	}
+	if info != nil {
+		t.Errorf("expected nil info, got %+v", info)
+	}
+}
+
+func TestAcquire_Forbidden(t *testing.T) {
+	gh, _ := newFakeGitHub(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+			return
+		}
+		gh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	acquired, err := c.Acquire(ctx, "deploy", &Holder{})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if acquired {
+		t.Error("expected acquired to be false")
+	}
+}
+
+// --------------- listRefs ---------------
+
+func TestListRefs_Paginated(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	gh.pageSize = 2
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		gh.refs["locks/"+name] = "sha-" + name
+	}
+	gh.refs["locksmith/x"] = "other"
+
+	refs, err := c.listRefs(ctx, "locks/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(refs) != 5 {
+		t.Fatalf("expected 5 refs, got %d: %+v", len(refs), refs)
+	}
+	if refs[4].Ref != "refs/locks/e" || refs[4].Object.SHA != "sha-e" {
+		t.Errorf("unexpected last ref: %+v", refs[4])
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	header := `<https://api.github.com/x?page=2>; rel="next", <https://api.github.com/x?page=5>; rel="last"`
+	if got := nextLink(header); got != "https://api.github.com/x?page=2" {
+		t.Errorf("unexpected next link: %q", got)
+	}
+	if got := nextLink(`<https://api.github.com/x?page=1>; rel="prev"`); got != "" {
+		t.Errorf("expected no next link, got %q", got)
+	}
+	if got := nextLink(""); got != "" {
+		t.Errorf("expected no next link, got %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// In fair mode waiters queue up with ticket refs under
+// refs/lock-queue/<name>/<timestamp>-<run_id>. Ticket names start with the
+// zero-padded enqueue time in nanoseconds, so sorting them by name yields
+// arrival order (up to clock skew between runners).
+
+func (c *Client) queuePath(lockName string) string {
+	return fmt.Sprintf("lock-queue/%s/", lockName)
+}
+
+// Enqueue adds a ticket for h to the queue of the lock and returns the ticket
+// name. The ticket expires ttl seconds after its last renewal, so tickets of
+// waiters that died are eventually reaped by the waiters behind them.
+func (c *Client) Enqueue(ctx context.Context, lockName string, h *Holder, ttl int) (string, error) {
+	meta := *h
+	meta.AcquiredAt = time.Now().UTC()
+	meta.RenewedAt = meta.AcquiredAt
+	meta.TTL = ttl
+
+	sha, err := c.CreateCommit(ctx, lockName, &meta, "")
+	if err != nil {
+		return "", err
+	}
+
+	ticket := fmt.Sprintf("%019d-%d", meta.AcquiredAt.UnixNano(), h.RunID)
+	created, err := c.createRef(ctx, c.queuePath(lockName)+ticket, sha)
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return "", fmt.Errorf("ticket %s already exists", ticket)
+	}
+	return ticket, nil
+}
+
+// QueuePosition returns the 1-based position of ticket among the live
+// tickets in the queue of the lock, removing expired tickets ahead of it.
+// Returns 0 if the ticket is no longer queued, e.g. because it expired and
+// was reaped by another waiter.
+//
+// seen caches the tickets read by earlier calls by commit SHA, so that a
+// ticket is only read again once it was renewed; it may be nil. Entries of
+// tickets that left the queue are dropped.
+func (c *Client) QueuePosition(ctx context.Context, lockName, ticket string, seen map[string]*Info) (int, error) {
+	prefix := c.queuePath(lockName)
+	refs, err := c.listRefs(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	tickets := make([]string, 0, len(refs))
+	shas := make(map[string]string, len(refs))
+	live := make(map[string]bool, len(refs))
+	for _, r := range refs {
+		t := strings.TrimPrefix(r.Ref, "refs/"+prefix)
+		tickets = append(tickets, t)
+		shas[t] = r.Object.SHA
+		live[r.Object.SHA] = true
+	}
+	sort.Strings(tickets)
+	for sha := range seen {
+		if !live[sha] {
+			delete(seen, sha)
+		}
+	}
+
+	pos := 1
+	for _, t := range tickets {
+		if t == ticket {
+			return pos, nil
+		}
+		if t > ticket {
+			break
+		}
+
+		info, ok := seen[shas[t]]
+		if !ok {
+			info, err = c.info(ctx, shas[t])
+			if err != nil {
+				return 0, err
+			}
+			if seen != nil {
+				seen[shas[t]] = info
+			}
+		}
+		if info.Stale(time.Now(), 0) {
+			// Losing a race to reap the same ticket is harmless.
+			_ = c.deleteRef(ctx, prefix+t, info.SHA)
+			continue
+		}
+		pos++
+	}
+	return 0, nil
+}
+
+// RenewTicket extends the lease of a ticket enqueued with token to ttl seconds
+// from now. Returns ErrNotOwner if the ticket was reaped.
+func (c *Client) RenewTicket(ctx context.Context, lockName, ticket, token string, ttl int) error {
+	return c.renew(ctx, c.queuePath(lockName)+ticket, lockName, token, ttl)
+}
+
+// Dequeue removes a ticket from the queue of the lock. Ticket names are
+// unique, so a failed removal may be repeated without checking the ticket.
+func (c *Client) Dequeue(ctx context.Context, lockName, ticket string) error {
+	return c.deleteRef(ctx, c.queuePath(lockName)+ticket, "")
+}
@@ -0,0 +1,156 @@
+package lock
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnqueue_Ticket(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	ticket, err := c.Enqueue(ctx, "deploy", &Holder{RunID: 42, Token: "mine"}, 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(ticket, "-42") {
+		t.Errorf("expected ticket to end with run id, got %q", ticket)
+	}
+	sha, ok := gh.refs["lock-queue/deploy/"+ticket]
+	if !ok {
+		t.Fatalf("expected refs/lock-queue/deploy/%s to be created", ticket)
+	}
+	if h := parseMessage(gh.commits[sha]); h == nil || h.TTL != 60 || h.Token != "mine" {
+		t.Errorf("unexpected ticket holder: %+v", h)
+	}
+}
+
+func TestQueuePosition_Order(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	first, _ := c.Enqueue(ctx, "deploy", &Holder{RunID: 1}, 60)
+	second, _ := c.Enqueue(ctx, "deploy", &Holder{RunID: 2}, 60)
+	other, _ := c.Enqueue(ctx, "deploy-other", &Holder{RunID: 3}, 60)
+
+	for ticket, want := range map[string]int{first: 1, second: 2} {
+		pos, err := c.QueuePosition(ctx, "deploy", ticket, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pos != want {
+			t.Errorf("expected position %d for %s, got %d", want, ticket, pos)
+		}
+	}
+	if pos, _ := c.QueuePosition(ctx, "deploy-other", other, nil); pos != 1 {
+		t.Errorf("expected queues to be separate, got position %d", pos)
+	}
+}
+
+func TestQueuePosition_ReapsExpired(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	// A waiter that died long ago.
+	msg, _ := commitMessage("deploy", &Holder{RenewedAt: time.Now().Add(-time.Hour), TTL: 60})
+	gh.refs["lock-queue/deploy/0000000000000000001-1"] = gh.addCommit(msg, time.Now())
+
+	ticket, _ := c.Enqueue(ctx, "deploy", &Holder{RunID: 2}, 60)
+	pos, err := c.QueuePosition(ctx, "deploy", ticket, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pos != 1 {
+		t.Errorf("expected position 1, got %d", pos)
+	}
+	if _, ok := gh.refs["lock-queue/deploy/0000000000000000001-1"]; ok {
+		t.Error("expected expired ticket to be reaped")
+	}
+}
+
+func TestQueuePosition_TicketGone(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	first, _ := c.Enqueue(ctx, "deploy", &Holder{RunID: 1}, 60)
+	pos, err := c.QueuePosition(ctx, "deploy", "9999999999999999999-2", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pos != 0 {
+		t.Errorf("expected position 0 for a missing ticket, got %d", pos)
+	}
+	if pos, _ := c.QueuePosition(ctx, "deploy", first, nil); pos != 1 {
+		t.Errorf("expected position 1, got %d", pos)
+	}
+}
+
+func TestQueuePosition_CachesTickets(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	first, _ := c.Enqueue(ctx, "deploy", &Holder{RunID: 1, Token: "first"}, 60)
+	_, _ = c.Enqueue(ctx, "deploy", &Holder{RunID: 2}, 60)
+	last, _ := c.Enqueue(ctx, "deploy", &Holder{RunID: 3}, 60)
+
+	seen := map[string]*Info{}
+	reads := gh.reads
+	for range 3 {
+		if pos, err := c.QueuePosition(ctx, "deploy", last, seen); err != nil || pos != 3 {
+			t.Fatalf("expected position 3, got %d, %v", pos, err)
+		}
+	}
+	if n := gh.reads - reads; n != 2 {
+		t.Errorf("expected each ticket ahead to be read once, got %d reads", n)
+	}
+
+	// A renewed ticket is read again, and its old commit forgotten.
+	before := gh.refs["lock-queue/deploy/"+first]
+	if err := c.RenewTicket(ctx, "deploy", first, "first", 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reads = gh.reads
+	if pos, err := c.QueuePosition(ctx, "deploy", last, seen); err != nil || pos != 3 {
+		t.Fatalf("expected position 3, got %d, %v", pos, err)
+	}
+	if n := gh.reads - reads; n != 1 {
+		t.Errorf("expected the renewed ticket to be read again, got %d reads", n)
+	}
+	if _, ok := seen[before]; ok || len(seen) != 2 {
+		t.Errorf("expected only the current tickets to be cached, got %v", seen)
+	}
+}
+
+func TestRenewTicket(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	ticket, _ := c.Enqueue(ctx, "deploy", &Holder{Token: "mine"}, 60)
+	before := gh.refs["lock-queue/deploy/"+ticket]
+
+	if err := c.RenewTicket(ctx, "deploy", ticket, "mine", 60); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gh.refs["lock-queue/deploy/"+ticket] == before {
+		t.Error("expected ticket to be renewed")
+	}
+	if err := c.RenewTicket(ctx, "deploy", ticket, "theirs", 60); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner, got %v", err)
+	}
+
+	// The lease may be stretched to outlast a long wait.
+	if err := c.RenewTicket(ctx, "deploy", ticket, "mine", 900); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h := parseMessage(gh.commits[gh.refs["lock-queue/deploy/"+ticket]]); h == nil || h.TTL != 900 {
+		t.Errorf("expected the new lease, got %+v", h)
+	}
+}
+
+func TestDequeue(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	ticket, _ := c.Enqueue(ctx, "deploy", &Holder{}, 60)
+	if err := c.Dequeue(ctx, "deploy", ticket); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := gh.refs["lock-queue/deploy/"+ticket]; ok {
+		t.Error("expected ticket to be removed")
+	}
+}
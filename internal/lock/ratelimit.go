@@ -0,0 +1,138 @@
+package lock
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitError is returned when GitHub rejects a request for exceeding a
+// primary or secondary rate limit, and the wait it asks for is too long to
+// sit out within the request. It matches ErrRateLimited.
+type RateLimitError struct {
+	Status    int           // HTTP status of the rejected request, 403 or 429
+	RequestID string        // X-GitHub-Request-Id of the rejected request
+	Wait      time.Duration // time until requests are accepted again
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("API rate limit exceeded (status %d), retry in %s", e.Status, e.Wait.Round(time.Second))
+	if e.RequestID != "" {
+		msg += " (request ID " + e.RequestID + ")"
+	}
+	return msg
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// rateLimits tracks the primary rate limit budget GitHub reports with every
+// response, and until when GitHub asked to hold off after rejecting a
+// request.
+type rateLimits struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	retryAt   time.Time
+}
+
+// update records the budget reported in the headers of a response, if any.
+func (r *rateLimits) update(h http.Header) {
+	limit, err1 := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limit, r.remaining, r.reset = limit, remaining, time.Unix(reset, 0)
+}
+
+// block records that GitHub rejected a request for exceeding a rate limit and
+// asked to wait until t.
+func (r *rateLimits) block(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t.After(r.retryAt) {
+		r.retryAt = t
+	}
+}
+
+// throttle stretches the poll interval d as the budget runs low: once less
+// than a quarter of it remains, d grows in proportion to how far below a
+// quarter the budget is, up to the time left until the budget resets. After
+// a rejected request, d lasts at least until GitHub accepts requests again.
+func (r *rateLimits) throttle(d time.Duration, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return max(r.budget(d, now), r.retryAt.Sub(now))
+}
+
+func (r *rateLimits) budget(d time.Duration, now time.Time) time.Duration {
+	if r.limit == 0 || !now.Before(r.reset) {
+		return d
+	}
+	untilReset := r.reset.Sub(now)
+	if r.remaining <= 0 {
+		return max(d, untilReset)
+	}
+	reserve := r.limit / 4
+	if r.remaining >= reserve {
+		return d
+	}
+	return max(d, min(d*time.Duration(reserve)/time.Duration(r.remaining), untilReset))
+}
+
+// Throttle returns the poll interval d, stretched to spare the API rate
+// limit when the remaining budget runs low, and to sit out the wait of a
+// RateLimitError.
+func (c *Client) Throttle(d time.Duration) time.Duration {
+	return c.limits.throttle(d, time.Now())
+}
+
+// rateLimited reports whether resp rejects the request for exceeding a rate
+// limit. GitHub answers 429 or 403 to both primary and secondary limits; a
+// 403 is told apart from a permission error by its headers or, for secondary
+// limits that carry none, by its message.
+func rateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+	default:
+		return false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "" {
+		return true
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return strings.Contains(strings.ToLower(string(body)), "rate limit")
+}
+
+// retryAfter returns how long to wait before repeating a request rejected by
+// a rate limit: as long as Retry-After says, until the primary limit resets
+// if it is exhausted, and otherwise a minute, as GitHub advises for secondary
+// limits.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Duration(max(0, secs)) * time.Second
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return max(time.Second, time.Unix(reset, 0).Sub(now))
+		}
+	}
+	return time.Minute
+}
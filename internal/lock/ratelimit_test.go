@@ -0,0 +1,258 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_RetriesAfterWait(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"You have exceeded a secondary rate limit."}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	// Even a ref creation, which isn't retried on errors, is repeated.
+	c := newTestClient(srv.URL)
+	created, err := c.createRef(ctx, "locks/deploy", "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !created {
+		t.Error("expected ref to be created")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRateLimit_PrimaryExhausted(t *testing.T) {
+	var calls atomic.Int32
+	reset := time.Now().Add(time.Hour).Unix()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"API rate limit exceeded for installation."}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	_, err := c.getRefSHA(ctx, "locks/deploy")
+	var rle *RateLimitError
+	if !errors.As(err, &rle) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rle.Status != http.StatusForbidden || rle.Wait < 59*time.Minute {
+		t.Errorf("unexpected rate limit error: %+v", rle)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single request, got %d", n)
+	}
+	if d := c.Throttle(10 * time.Second); d < 59*time.Minute {
+		t.Errorf("expected polling to wait for the reset, got %v", d)
+	}
+}
+
+func TestRateLimit_SecondaryWithoutHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"You have exceeded a secondary rate limit. Please wait a few minutes before you try again."}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	c.http.Transport.(*retryTransport).maxWait = time.Second
+	_, err := c.getRefSHA(ctx, "locks/deploy")
+	var rle *RateLimitError
+	if !errors.As(err, &rle) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rle.Wait != time.Minute {
+		t.Errorf("expected to be told to wait a minute, got %v", rle.Wait)
+	}
+	// The next poll sits out the wait although the primary budget is fine.
+	if d := c.Throttle(10 * time.Second); d < 59*time.Second {
+		t.Errorf("expected polling to wait for the rate limit, got %v", d)
+	}
+}
+
+func TestRateLimit_WaitDeadline(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+	}{
+		{"wait deadline", func() (context.Context, context.CancelFunc) {
+			return WithWaitDeadline(ctx, time.Now().Add(time.Second)), func() {}
+		}},
+		{"context deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(ctx, time.Second)
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"message":"You have exceeded a secondary rate limit."}`))
+			}))
+			defer srv.Close()
+
+			c := newTestClient(srv.URL)
+			reqCtx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			_, err := c.getRefSHA(reqCtx, "locks/deploy")
+			var rle *RateLimitError
+			if !errors.As(err, &rle) {
+				t.Fatalf("expected RateLimitError, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("expected to give up at once, took %v", elapsed)
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("expected a single request, got %d", n)
+			}
+			// The caller's polling sits out the wait instead.
+			if d := c.Throttle(10 * time.Second); d < 29*time.Second {
+				t.Errorf("expected polling to wait for the rate limit, got %v", d)
+			}
+		})
+	}
+}
+
+func TestWaitDeadline(t *testing.T) {
+	soon := time.Now().Add(time.Minute)
+	later := soon.Add(time.Minute)
+	if got := waitDeadline(ctx); !got.IsZero() {
+		t.Errorf("expected no deadline, got %v", got)
+	}
+	if got := waitDeadline(WithWaitDeadline(ctx, soon)); !got.Equal(soon) {
+		t.Errorf("expected the wait deadline, got %v", got)
+	}
+	timeout, cancel := context.WithDeadline(ctx, soon)
+	defer cancel()
+	if got := waitDeadline(WithWaitDeadline(timeout, later)); !got.Equal(soon) {
+		t.Errorf("expected the earlier context deadline, got %v", got)
+	}
+	if got := waitDeadline(WithWaitDeadline(WithWaitDeadline(ctx, soon), time.Time{})); !got.IsZero() {
+		t.Errorf("expected the wait deadline to be lifted, got %v", got)
+	}
+}
+
+func TestRateLimit_TooManyRequests(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	var rle *RateLimitError
+	if err := c.ForceRelease(ctx, "deploy"); !errors.As(err, &rle) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+}
+
+func TestRateLimit_PermissionErrorNotRateLimited(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	err := c.ForceRelease(ctx, "deploy")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var rle *RateLimitError
+	if errors.As(err, &rle) {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Resource not accessible") {
+		t.Errorf("expected the response message in the error, got %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single request, got %d", n)
+	}
+}
+
+func TestRateLimits_Throttle(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		name      string
+		limit     int
+		remaining int
+		reset     time.Time
+		want      time.Duration
+	}{
+		{"unknown", 0, 0, time.Time{}, 10 * time.Second},
+		{"plenty left", 1000, 800, now.Add(time.Hour), 10 * time.Second},
+		{"at reserve", 1000, 250, now.Add(time.Hour), 10 * time.Second},
+		{"running low", 1000, 50, now.Add(time.Hour), 50 * time.Second},
+		{"almost out", 1000, 1, now.Add(10 * time.Minute), 10 * time.Minute},
+		{"exhausted", 1000, 0, now.Add(time.Hour), time.Hour},
+		{"reset passed", 1000, 0, now.Add(-time.Minute), 10 * time.Second},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &rateLimits{limit: tt.limit, remaining: tt.remaining, reset: tt.reset}
+			if got := r.throttle(10*time.Second, now); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRateLimits_Block(t *testing.T) {
+	now := time.Now()
+	r := &rateLimits{limit: 1000, remaining: 800, reset: now.Add(time.Hour)}
+	r.block(now.Add(2 * time.Minute))
+	r.block(now.Add(time.Minute))
+	if got := r.throttle(10*time.Second, now); got != 2*time.Minute {
+		t.Errorf("expected the longest wait, got %v", got)
+	}
+	if got := r.throttle(10*time.Second, now.Add(3*time.Minute)); got != 10*time.Second {
+		t.Errorf("expected the wait to be over, got %v", got)
+	}
+}
+
+func TestRateLimits_Update(t *testing.T) {
+	r := &rateLimits{}
+	h := http.Header{}
+	h.Set("X-RateLimit-Limit", "5000")
+	h.Set("X-RateLimit-Remaining", "4321")
+	h.Set("X-RateLimit-Reset", "1700000000")
+	r.update(h)
+	if r.limit != 5000 || r.remaining != 4321 || !r.reset.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected budget: %+v", r)
+	}
+
+	r.update(http.Header{})
+	if r.remaining != 4321 {
+		t.Error("expected a response without headers to keep the budget")
+	}
+}
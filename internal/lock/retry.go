@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// retryTransport retries requests that are safe to repeat when they fail with
+// a transient error: a network error or a 5xx response. Retries are delayed
+// by capped exponential backoff with full jitter, so that many jobs hitting
+// the same outage don't retry in lockstep.
+//
+// Requests rejected by a rate limit are repeated after the wait GitHub asks
+// for, whatever their method, as long as that wait is short and ends before
+// the wait deadline of the request; otherwise they fail with a
+// RateLimitError. Every response updates the rate limit budget.
+type retryTransport struct {
+	next     http.RoundTripper
+	limits   *rateLimits
+	attempts int           // total attempts per request, including the first
+	base     time.Duration // backoff before the first retry, before jitter
+	limit    time.Duration // upper bound of the backoff, before jitter
+	maxWait  time.Duration // longest rate limit wait to sit out
+}
+
+func newRetryTransport(limits *rateLimits) *retryTransport {
+	next := http.DefaultTransport.(*http.Transport).Clone()
+	next.ResponseHeaderTimeout = 10 * time.Second
+	return &retryTransport{
+		next:     next,
+		limits:   limits,
+		attempts: 4,
+		base:     500 * time.Millisecond,
+		limit:    8 * time.Second,
+		maxWait:  time.Minute,
+	}
+}
+
+type idempotentKey struct{}
+
+// idempotent marks requests made with the returned context as safe to retry
+// although their method isn't idempotent, e.g. creating a git object, which
+// at worst leaves an unreferenced duplicate behind.
+func idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+type waitDeadlineKey struct{}
+
+// WithWaitDeadline returns a context whose requests don't sit out a rate limit
+// that lasts past t, e.g. the deadline of an acquisition: they fail with a
+// RateLimitError right away, leaving the wait to the caller. The deadline of
+// ctx, if any, bounds the wait likewise. A zero t lifts a wait deadline set
+// on ctx before.
+func WithWaitDeadline(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, waitDeadlineKey{}, t)
+}
+
+// waitDeadline returns when the waits of requests made with ctx must end, or
+// the zero time if they may last as long as maxWait.
+func waitDeadline(ctx context.Context) time.Time {
+	t, _ := ctx.Value(waitDeadlineKey{}).(time.Time)
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		t = d
+	}
+	return t
+}
+
+// retryable reports whether req may be sent again after a failure that
+// leaves unknown whether it took effect.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut:
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rewindable := req.Body == nil || req.GetBody != nil
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.limits.update(resp.Header)
+		}
+
+		var wait time.Duration
+		switch {
+		case err == nil && rateLimited(resp):
+			// The request was rejected without effect, so it can be repeated.
+			now := time.Now()
+			wait = retryAfter(resp, now)
+			deadline := waitDeadline(req.Context())
+			if !rewindable || attempt == t.attempts || wait > t.maxWait || !deadline.IsZero() && now.Add(wait).After(deadline) {
+				_ = resp.Body.Close()
+				t.limits.block(time.Now().Add(wait))
+				return nil, &RateLimitError{Status: resp.StatusCode, RequestID: resp.Header.Get("X-GitHub-Request-Id"), Wait: wait}
+			}
+		case rewindable && retryable(req) && attempt < t.attempts && transient(resp, err):
+			wait = backoff(attempt, t.base, t.limit)
+		default:
+			return resp, err
+		}
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		req, err = rewind(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// transient reports whether a request that failed with resp or err may
+// succeed when repeated.
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewind returns a copy of req with a fresh body, to send it again.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = body
+	return req, nil
+}
+
+// backoff returns the delay before retry number attempt (starting at 1): a
+// random duration up to base doubled for every earlier retry, capped at limit.
+func backoff(attempt int, base, limit time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	return rand.N(min(d, limit)) + 1
+}
+
+// Jitter returns d scaled by a random factor between 0.5 and 1.5, spreading
+// out waiters that poll the same lock on the same interval.
+func Jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d/2 + rand.N(d)
+}
@@ -0,0 +1,249 @@
+package lock
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry_TransientThenSuccess(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object":{"sha":"abc123"}}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	sha, err := c.getRefSHA(ctx, "locks/deploy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sha != "abc123" {
+		t.Errorf("expected abc123, got %q", sha)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestRetry_GivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	if err := c.ForceRelease(ctx, "deploy"); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("expected 4 requests, got %d", n)
+	}
+}
+
+func TestRetry_NotOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	if _, err := c.getRefSHA(ctx, "locks/deploy"); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single request, got %d", n)
+	}
+}
+
+func TestRetry_CreateRefNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	if _, err := c.createRef(ctx, "locks/deploy", "abc123"); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single request, got %d", n)
+	}
+}
+
+func TestRetry_ReplaysBody(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"sha":"tree1"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	sha, err := c.lockTree(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sha != "tree1" {
+		t.Errorf("expected tree1, got %q", sha)
+	}
+	if len(bodies) != 2 || bodies[0] == "" || bodies[1] != bodies[0] {
+		t.Errorf("expected the body to be sent twice, got %q", bodies)
+	}
+}
+
+func TestRetry_AdvanceAlreadyApplied(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first update goes through, but its response is lost.
+	var patched atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PATCH" && !patched.Swap(true) {
+			gh.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		gh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c.baseURL = srv.URL
+
+	if err := c.Renew(ctx, "deploy", "mine"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRetry_ReleaseAppliedThenReacquired(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The deletion goes through but its response is lost, and a waiter
+	// acquires the lock before it could be sent again.
+	var deletes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && deletes.Add(1) == 1 {
+			gh.ServeHTTP(httptest.NewRecorder(), r)
+			gh.refs["locks/deploy"] = gh.addCommit("other", time.Now())
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c.baseURL = srv.URL
+
+	released, err := c.Release(ctx, "deploy", "mine")
+	if err != nil || !released {
+		t.Fatalf("expected lock to be released, got %t, %v", released, err)
+	}
+	if n := deletes.Load(); n != 1 {
+		t.Errorf("expected a single deletion, got %d", n)
+	}
+	if _, ok := gh.refs["locks/deploy"]; !ok {
+		t.Error("expected the new holder's lock to be kept")
+	}
+}
+
+func TestRetry_ReleaseNotApplied(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	if _, err := c.Acquire(ctx, "deploy", &Holder{Token: "mine"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var deletes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && deletes.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		gh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c.baseURL = srv.URL
+
+	if err := c.ForceRelease(ctx, "deploy"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := deletes.Load(); n != 2 {
+		t.Errorf("expected the deletion to be repeated, got %d", n)
+	}
+	if _, ok := gh.refs["locks/deploy"]; ok {
+		t.Error("expected the lock to be released")
+	}
+}
+
+func TestRetry_StopsWhenCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv.URL)
+	rt := c.http.Transport.(*retryTransport)
+	rt.base, rt.limit = time.Hour, time.Hour
+
+	cancelled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.getRefSHA(cancelled, "locks/deploy"); err == nil {
+		t.Fatal("expected error")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected the backoff to be cut short")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, ceiling := range map[int]time.Duration{
+		1:   100 * time.Millisecond,
+		2:   200 * time.Millisecond,
+		3:   400 * time.Millisecond,
+		4:   time.Second,
+		9:   time.Second,
+		100: time.Second,
+	} {
+		for range 100 {
+			d := backoff(attempt, 100*time.Millisecond, time.Second)
+			if d <= 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, expected within (0, %v]", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for range 100 {
+		d := Jitter(10 * time.Second)
+		if d < 5*time.Second || d >= 15*time.Second {
+			t.Fatalf("Jitter(10s) = %v, expected within [5s, 15s)", d)
+		}
+	}
+	if d := Jitter(0); d != 0 {
+		t.Errorf("expected Jitter(0) = 0, got %v", d)
+	}
+}
@@ -0,0 +1,144 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// WorkflowRun is the state of a workflow run attempt as reported by the
+// Actions API.
+type WorkflowRun struct {
+	// Status is queued, in_progress, completed, etc.
+	Status string `json:"status"`
+	// Conclusion is success, failure, cancelled, etc. once completed.
+	Conclusion string `json:"conclusion"`
+}
+
+// Finished reports whether the run will no longer release its locks itself.
+func (r *WorkflowRun) Finished() bool {
+	return r.Status == "completed" || r.Conclusion == "cancelled"
+}
+
+// GetRun returns the given attempt of workflow run runID in repo, or the
+// latest attempt if attempt is 0. Returns nil if the run doesn't exist.
+//
+// GitHub also answers 404 when the token may not see the repository, so a
+// run is only reported missing if the repository is visible; otherwise the
+// 404 is returned as an error matching ErrNotFound.
+func (c *Client) GetRun(ctx context.Context, repo string, runID int64, attempt int) (*WorkflowRun, error) {
+	path := fmt.Sprintf("/repos/%s/actions/runs/%d", repo, runID)
+	if attempt > 0 {
+		path += fmt.Sprintf("/attempts/%d", attempt)
+	}
+	req, err := c.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var run WorkflowRun
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			return nil, err
+		}
+		return &run, nil
+	case http.StatusNotFound:
+		apiErr := newAPIError(resp)
+		visible, err := c.repoVisible(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			return nil, apiErr
+		}
+		return nil, nil
+	}
+
+	return nil, newAPIError(resp)
+}
+
+// repoVisible reports whether the token may see repo.
+func (c *Client) repoVisible(ctx context.Context, repo string) (bool, error) {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/repos/%s", repo), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		return false, nil
+	}
+	return false, newAPIError(resp)
+}
+
+// WorkflowJob is the state of a job of a workflow run attempt as reported by
+// the Actions API.
+type WorkflowJob struct {
+	// Name is the job's name, which is its ID in the workflow unless the job
+	// sets a name or belongs to a matrix.
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+}
+
+// Finished reports whether the job will no longer release its locks itself.
+func (j *WorkflowJob) Finished() bool {
+	return j.Status == "completed"
+}
+
+// GetJobs returns the jobs of the given attempt of workflow run runID in repo,
+// or of the latest attempt if attempt is 0.
+func (c *Client) GetJobs(ctx context.Context, repo string, runID int64, attempt int) ([]WorkflowJob, error) {
+	path := fmt.Sprintf("/repos/%s/actions/runs/%d/jobs", repo, runID)
+	if attempt > 0 {
+		path = fmt.Sprintf("/repos/%s/actions/runs/%d/attempts/%d/jobs", repo, runID, attempt)
+	}
+
+	var jobs []WorkflowJob
+	url := c.baseURL + path + "?per_page=100"
+	for url != "" {
+		req, err := c.newRequestURL(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := newAPIError(resp)
+			_ = resp.Body.Close()
+			return nil, err
+		}
+
+		var page struct {
+			Jobs []WorkflowJob `json:"jobs"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, page.Jobs...)
+		url = nextLink(resp.Header.Get("Link"))
+	}
+	return jobs, nil
+}
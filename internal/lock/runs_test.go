@@ -0,0 +1,112 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/other/repo/actions/runs/1":
+			_, _ = w.Write([]byte(`{"status":"in_progress","conclusion":null}`))
+		case "/repos/other/repo/actions/runs/1/attempts/2":
+			_, _ = w.Write([]byte(`{"status":"completed","conclusion":"cancelled"}`))
+		case "/repos/other/repo":
+			_, _ = w.Write([]byte(`{"full_name":"other/repo"}`))
+		case "/repos/other/repo/actions/runs/3", "/repos/private/repo/actions/runs/3", "/repos/private/repo":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(srv.URL)
+
+	run, err := c.GetRun(ctx, "other/repo", 1, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run == nil || run.Status != "in_progress" || run.Finished() {
+		t.Errorf("expected run in progress, got %+v", run)
+	}
+
+	run, err = c.GetRun(ctx, "other/repo", 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run == nil || run.Conclusion != "cancelled" || !run.Finished() {
+		t.Errorf("expected cancelled attempt, got %+v", run)
+	}
+
+	run, err = c.GetRun(ctx, "other/repo", 3, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run != nil {
+		t.Errorf("expected missing run, got %+v", run)
+	}
+
+	// A 404 from a repository the token can't see says nothing about the run.
+	run, err = c.GetRun(ctx, "private/repo", 3, 0)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if run != nil {
+		t.Errorf("expected no run, got %+v", run)
+	}
+
+	if _, err := c.GetRun(ctx, "other/repo", 4, 0); err == nil {
+		t.Error("expected error for server error")
+	}
+}
+
+func TestWorkflowRun_Finished(t *testing.T) {
+	cases := []struct {
+		run  WorkflowRun
+		want bool
+	}{
+		{WorkflowRun{Status: "queued"}, false},
+		{WorkflowRun{Status: "in_progress"}, false},
+		{WorkflowRun{Status: "waiting"}, false},
+		{WorkflowRun{Status: "completed", Conclusion: "success"}, true},
+		{WorkflowRun{Status: "completed", Conclusion: "failure"}, true},
+		{WorkflowRun{Status: "cancelled", Conclusion: "cancelled"}, true},
+	}
+	for _, tc := range cases {
+		if got := tc.run.Finished(); got != tc.want {
+			t.Errorf("Finished(%+v) = %v, want %v", tc.run, got, tc.want)
+		}
+	}
+}
+
+func TestGetJobs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/other/repo/actions/runs/1/attempts/2/jobs" && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?per_page=100&page=2>; rel="next"`, r.Host, r.URL.Path))
+			_, _ = w.Write([]byte(`{"total_count":2,"jobs":[{"name":"build","status":"completed","conclusion":"failure"}]}`))
+		case r.URL.Path == "/repos/other/repo/actions/runs/1/attempts/2/jobs":
+			_, _ = w.Write([]byte(`{"total_count":2,"jobs":[{"name":"deploy","status":"in_progress","conclusion":null}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	c := newTestClient(srv.URL)
+
+	jobs, err := c.GetJobs(ctx, "other/repo", 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Name != "build" || !jobs[0].Finished() || jobs[1].Name != "deploy" || jobs[1].Finished() {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+
+	if _, err := c.GetJobs(ctx, "other/repo", 3, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
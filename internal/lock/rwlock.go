@@ -0,0 +1,66 @@
+package lock
+
+import (
+	"context"
+	"strings"
+)
+
+// A read-write lock is stored as a writer ref refs/locks/<name>/writer and one
+// reader ref per shared holder under refs/locks/<name>/readers/. A writer
+// takes the writer ref first, which blocks new readers, and then waits for the
+// existing readers to drain.
+
+// WriterName returns the lock name of the writer ref of a read-write lock.
+func WriterName(lockName string) string {
+	return lockName + "/writer"
+}
+
+// ReaderName returns the lock name of the reader ref with the given id.
+func ReaderName(lockName, id string) string {
+	return lockName + "/readers/" + id
+}
+
+// Readers returns the lock names of all reader refs of a read-write lock.
+func (c *Client) Readers(ctx context.Context, lockName string) ([]string, error) {
+	prefix := c.refPath(ReaderName(lockName, ""))
+	refs, err := c.listRefs(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(refs))
+	for _, r := range refs {
+		names = append(names, ReaderName(lockName, strings.TrimPrefix(r.Ref, "refs/"+prefix)))
+	}
+	return names, nil
+}
+
+// ActiveReaders returns the number of live readers of a read-write lock,
+// removing readers for which stale reports true.
+func (c *Client) ActiveReaders(ctx context.Context, lockName string, stale func(*Info) bool) (int, error) {
+	names, err := c.Readers(ctx, lockName)
+	if err != nil {
+		return 0, err
+	}
+
+	active := 0
+	for _, name := range names {
+		info, err := c.Inspect(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+		if info == nil {
+			continue
+		}
+		if stale(info) {
+			// Reader refs are unique per holder, so nobody else can have
+			// re-acquired this one in the meantime.
+			if err := c.ForceRelease(ctx, name); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		active++
+	}
+	return active, nil
+}
@@ -0,0 +1,58 @@
+package lock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReaders(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+	gh.refs["locks/env/readers/a"] = "sha-a"
+	gh.refs["locks/env/readers/b"] = "sha-b"
+	gh.refs["locks/env/writer"] = "sha-w"
+	gh.refs["locks/env-2/readers/c"] = "sha-c"
+
+	names, err := c.Readers(ctx, "env")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 2 || names[0] != "env/readers/a" || names[1] != "env/readers/b" {
+		t.Errorf("unexpected readers: %v", names)
+	}
+}
+
+func TestActiveReaders_ReapsStale(t *testing.T) {
+	gh, c := newFakeGitHub(t)
+
+	if _, err := c.Acquire(ctx, ReaderName("env", "live"), &Holder{TTL: 60}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, _ := commitMessage("env", &Holder{RenewedAt: time.Now().Add(-time.Hour), TTL: 60})
+	gh.refs["locks/env/readers/dead"] = gh.addCommit(msg, time.Now())
+
+	n, err := c.ActiveReaders(ctx, "env", func(info *Info) bool { return info.Stale(time.Now(), 0) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 active reader, got %d", n)
+	}
+	if _, ok := gh.refs["locks/env/readers/dead"]; ok {
+		t.Error("expected stale reader to be removed")
+	}
+	if _, ok := gh.refs["locks/env/readers/live"]; !ok {
+		t.Error("expected live reader to be kept")
+	}
+}
+
+func TestActiveReaders_None(t *testing.T) {
+	_, c := newFakeGitHub(t)
+
+	n, err := c.ActiveReaders(ctx, "env", func(*Info) bool { return false })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no readers, got %d", n)
+	}
+}
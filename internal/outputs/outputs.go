@@ -6,15 +6,34 @@ import (
 )
 
 func Set(key, value string) {
-	path := os.Getenv("GITHUB_OUTPUT")
+	write("GITHUB_OUTPUT", "set-output", key, value)
+}
+
+// SaveState stores a value for the action's post step, which reads it back
+// from the STATE_<key> environment variable.
+func SaveState(key, value string) {
+	write("GITHUB_STATE", "save-state", key, value)
+}
+
+// ExportVariable sets an environment variable for the later steps of the job.
+// Outside of GitHub Actions there are no later steps, so it does nothing.
+func ExportVariable(key, value string) {
+	if os.Getenv("GITHUB_ENV") == "" {
+		return
+	}
+	write("GITHUB_ENV", "set-env", key, value)
+}
+
+func write(fileEnv, command, key, value string) {
+	path := os.Getenv(fileEnv)
 	if path == "" {
-		fmt.Printf("::set-output name=%s::%s\n", key, value)
+		fmt.Printf("::%s name=%s::%s\n", command, key, value)
 		return
 	}
 
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "::error::Failed to open GITHUB_OUTPUT: %v\n", err)
+		fmt.Fprintf(os.Stderr, "::error::Failed to open %s: %v\n", fileEnv, err)
 		return
 	}
 	defer func() { _ = f.Close() }()
@@ -22,10 +41,33 @@ func Set(key, value string) {
 	_, _ = fmt.Fprintf(f, "%s=%s\n", key, value)
 }
 
+// Summary appends Markdown to the job summary. Outside of GitHub Actions it is
+// printed instead.
+func Summary(markdown string) {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		fmt.Print(markdown)
+		return
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "::error::Failed to open GITHUB_STEP_SUMMARY: %v\n", err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	_, _ = fmt.Fprint(f, markdown)
+}
+
 func Notice(msg string) {
 	fmt.Printf("::notice::%s\n", msg)
 }
 
+func Warning(msg string) {
+	fmt.Printf("::warning::%s\n", msg)
+}
+
 func Error(msg string) {
 	fmt.Printf("::error::%s\n", msg)
 }
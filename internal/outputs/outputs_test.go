@@ -82,6 +82,94 @@ func TestSet_WithoutGitHubOutput(t *testing.T) {
 	}
 }
 
+// --------------- SaveState ---------------
+
+func TestSaveState_WithGitHubState(t *testing.T) {
+	tmp, err := os.CreateTemp(t.TempDir(), "gh-state")
+	if err != nil {
+		t.Fatalf("create temp: %v", err)
+	}
+	_ = tmp.Close()
+
+	t.Setenv("GITHUB_STATE", tmp.Name())
+
+	SaveState("owner_token", "abc")
+
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if got := string(data); got != "owner_token=abc\n" {
+		t.Errorf("expected 'owner_token=abc\\n', got %q", got)
+	}
+}
+
+func TestSaveState_WithoutGitHubState(t *testing.T) {
+	t.Setenv("GITHUB_STATE", "")
+
+	got := captureStdout(t, func() {
+		SaveState("owner_token", "abc")
+	})
+	if got != "::save-state name=owner_token::abc\n" {
+		t.Errorf("expected save-state fallback, got %q", got)
+	}
+}
+
+// --------------- ExportVariable ---------------
+
+func TestExportVariable_WithGitHubEnv(t *testing.T) {
+	path := t.TempDir() + "/env"
+	t.Setenv("GITHUB_ENV", path)
+
+	ExportVariable("TOKEN", "abc")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if got := string(data); got != "TOKEN=abc\n" {
+		t.Errorf("expected 'TOKEN=abc\\n', got %q", got)
+	}
+}
+
+func TestExportVariable_WithoutGitHubEnv(t *testing.T) {
+	t.Setenv("GITHUB_ENV", "")
+
+	got := captureStdout(t, func() {
+		ExportVariable("TOKEN", "abc")
+	})
+	if got != "" {
+		t.Errorf("expected nothing outside of GitHub Actions, got %q", got)
+	}
+}
+
+// --------------- Summary ---------------
+
+func TestSummary_WithStepSummary(t *testing.T) {
+	path := t.TempDir() + "/summary.md"
+	t.Setenv("GITHUB_STEP_SUMMARY", path)
+
+	Summary("# Locks\n")
+	Summary("| a |\n")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if got := string(data); got != "# Locks\n| a |\n" {
+		t.Errorf("unexpected summary %q", got)
+	}
+}
+
+func TestSummary_WithoutStepSummary(t *testing.T) {
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+
+	output := captureStdout(t, func() { Summary("# Locks\n") })
+	if output != "# Locks\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+}
+
 // --------------- Notice ---------------
 
 func TestNotice(t *testing.T) {
@@ -93,6 +181,17 @@ func TestNotice(t *testing.T) {
 	}
 }
 
+// --------------- Warning ---------------
+
+func TestWarning(t *testing.T) {
+	got := captureStdout(t, func() {
+		Warning("lock not owned")
+	})
+	if got != "::warning::lock not owned\n" {
+		t.Errorf("expected warning, got %q", got)
+	}
+}
+
 // --------------- Error ---------------
 
 func TestError(t *testing.T) {